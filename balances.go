@@ -0,0 +1,80 @@
+package peluciopg
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"slices"
+	"strings"
+
+	"github.com/devmalloni/pelucio"
+	"github.com/gofrs/uuid/v5"
+)
+
+// balanceDelta is a signed change to the balance of an account in a currency.
+type balanceDelta struct {
+	AccountID uuid.UUID        `db:"account_id"`
+	Currency  pelucio.Currency `db:"currency"`
+	Amount    NullBigInt       `db:"amount"`
+}
+
+// balanceDeltas returns the non-zero changes the entries of transaction make
+// to the accounts balances, ordered by account and currency so concurrent
+// writers touch the balance rows in the same order.
+func balanceDeltas(transaction *pelucio.Transaction) []*balanceDelta {
+	deltas := []*balanceDelta{}
+	for accountID, balance := range transaction.BalancesByAccount(nil) {
+		deltas = append(deltas, newBalanceDeltas(accountID, balance)...)
+	}
+	sortBalanceDeltas(deltas)
+
+	return deltas
+}
+
+func newBalanceDeltas(accountID uuid.UUID, balance pelucio.Balance) []*balanceDelta {
+	deltas := []*balanceDelta{}
+	for currency, amount := range balance {
+		if amount == nil || amount.Sign() == 0 {
+			continue
+		}
+		deltas = append(deltas, &balanceDelta{
+			AccountID: accountID,
+			Currency:  currency,
+			Amount:    NullBigInt{Amount: new(big.Int).Set(amount), Valid: true},
+		})
+	}
+
+	return deltas
+}
+
+func sortBalanceDeltas(deltas []*balanceDelta) {
+	slices.SortFunc(deltas, func(a, b *balanceDelta) int {
+		if c := bytes.Compare(a.AccountID.Bytes(), b.AccountID.Bytes()); c != 0 {
+			return c
+		}
+		return strings.Compare(string(a.Currency), string(b.Currency))
+	})
+}
+
+// incrementBalances adds deltas to the stored balances in place, creating the
+// balance rows of currencies the accounts did not hold yet.
+func (rw *ReadWriterPG) incrementBalances(ctx context.Context, deltas []*balanceDelta) error {
+	return insertChunks(ctx, rw, `
+		INSERT INTO `+rw.table("account_balances")+` AS account_balances (account_id, currency, amount)
+		VALUES (:account_id, :currency, :amount)
+		ON CONFLICT (account_id, currency) DO UPDATE SET
+			amount = account_balances.amount + EXCLUDED.amount
+	`, deltas)
+}
+
+// accountColumns is the select list of accounts, with the balance assembled
+// from account_balances in the JSON shape of pelucio.Balance.
+func (rw *ReadWriterPG) accountColumns() string {
+	return `accounts.id, accounts.external_id, accounts.name, accounts.metadata, accounts.normal_side, accounts.version,
+		COALESCE((
+			SELECT jsonb_object_agg(account_balances.currency, account_balances.amount)
+			FROM ` + rw.table("account_balances") + ` AS account_balances
+			WHERE account_balances.account_id = accounts.id
+		), '{}'::jsonb) AS balance,
+		accounts.created_at, accounts.updated_at, accounts.deleted_at`
+}
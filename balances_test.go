@@ -0,0 +1,108 @@
+package peluciopg
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/devmalloni/pelucio"
+	"github.com/devmalloni/pelucio/x/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBalanceDeltas(t *testing.T) {
+	debitAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Debit))
+	creditAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Credit))
+
+	deposit := pelucio.Deposit("deposit", debitAccount.ID, creditAccount.ID, big.NewInt(100), "USD")
+	deltas := balanceDeltas(deposit)
+	assert.Len(t, deltas, 2)
+	for _, d := range deltas {
+		assert.Equal(t, pelucio.Currency("USD"), d.Currency)
+		assert.Equal(t, int64(100), d.Amount.Amount.Int64())
+	}
+
+	reversal := deposit.Reverse("reversal", "reversal", xtime.DefaultClock)
+	for _, d := range balanceDeltas(reversal) {
+		assert.Equal(t, int64(-100), d.Amount.Amount.Int64())
+	}
+}
+
+func TestWriteAccount_InsertWithBalance(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	acc := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Debit))
+	acc.Balance["BRL"] = big.NewInt(250)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO accounts").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO account_balances").
+		WithArgs(acc.ID, pelucio.Currency("BRL"), "250").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err := db.WriteAccount(context.Background(), acc, false)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWriteAccount_UpsertWithBalance(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	acc := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Debit))
+	acc.Balance["BRL"] = big.NewInt(250)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO accounts AS accounts .* RETURNING xmax = 0 AS inserted").
+		WillReturnRows(sqlmock.NewRows([]string{"inserted"}).AddRow(true))
+	mock.ExpectExec("INSERT INTO account_balances").
+		WithArgs(acc.ID, pelucio.Currency("BRL"), "250").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err := db.WriteAccount(context.Background(), acc, true)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWriteAccount_UpsertExistingKeepsBalance(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	acc := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Debit))
+	acc.Balance["BRL"] = big.NewInt(250)
+
+	// the stored balance already has the transactions posted since
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO accounts AS accounts .* RETURNING xmax = 0 AS inserted").
+		WillReturnRows(sqlmock.NewRows([]string{"inserted"}).AddRow(false))
+	mock.ExpectCommit()
+
+	err := db.WriteAccount(context.Background(), acc, true)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReadAccount_AssemblesBalance(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	acc := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Debit))
+
+	rows := sqlmock.
+		NewRows([]string{"id", "external_id", "name", "metadata", "normal_side", "version", "balance", "created_at", "updated_at", "deleted_at"}).
+		AddRow(acc.ID, acc.ExternalID, acc.Name, nil, acc.NormalSide, int64(1), []byte(`{"BRL": 123456789012345678901234567890, "USD": 5}`), acc.CreatedAt, nil, nil)
+
+	mock.ExpectQuery("SELECT (.+) FROM account_balances (.+) FROM accounts WHERE id = \\$1").
+		WithArgs(acc.ID).
+		WillReturnRows(rows)
+
+	result, err := db.ReadAccount(context.Background(), acc.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "123456789012345678901234567890", result.Balance.Get("BRL").String())
+	assert.Equal(t, int64(5), result.Balance.Get("USD").Int64())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,382 @@
+package peluciopg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+
+	"github.com/devmalloni/pelucio"
+	"github.com/gofrs/uuid/v5"
+	"github.com/lib/pq"
+)
+
+// BatchMode is what WriteTransactions does when some transactions of a batch
+// fail.
+type BatchMode int
+
+const (
+	// AllOrNothing posts a batch only if every transaction of it can be
+	// posted.
+	AllOrNothing BatchMode = iota
+	// BestEffort posts the transactions of a batch that can be posted and
+	// skips the others.
+	BestEffort
+)
+
+var ErrBatchFailed = errors.New("transactions of the batch failed")
+
+// batchInsertSize is how many rows a multi-row insert carries, keeping
+// statements well below the 65535 parameters Postgres accepts.
+const batchInsertSize = 1000
+
+// TransactionWrite is a transaction of a batch, along with the accounts it
+// moves, as given to WriteTransaction.
+type TransactionWrite struct {
+	Transaction *pelucio.Transaction
+	Accounts    []*pelucio.Account
+}
+
+// WriteTransactions posts a batch of transactions in one database
+// transaction, with multi-row inserts, bumping the version of every account
+// once and incrementing its balances once. The returned errors are the ones
+// of each write, nil for the ones posted.
+//
+// In AllOrNothing mode nothing is posted when any write fails: ErrBatchFailed
+// is returned, and the writes that did not fail themselves get it too. In
+// BestEffort mode the error is only set when the batch as a whole failed.
+// Errors other than ErrBatchFailed come with no per-write errors. Under
+// WithTx, a batch that fails leaves the caller's transaction as it found it.
+//
+// In PessimisticLocking mode the writes are checked in order against the
+// balances read under the lock, and the ones that would overdraw an account
+// fail with pelucio.ErrInsufficientBalance. Batches are neither retried nor
+// idempotent: a transaction posted before shows up as a failed write.
+func (rw *ReadWriterPG) WriteTransactions(ctx context.Context, writes []TransactionWrite, mode BatchMode) ([]error, error) {
+	errs := make([]error, len(writes))
+	pending := make([]int, 0, len(writes))
+	for i, write := range writes {
+		// the entries_balanced trigger only fires on commit, too late to
+		// tell which write is unbalanced
+		if errs[i] = checkBalanced(write.Transaction); errs[i] == nil {
+			pending = append(pending, i)
+		}
+	}
+	if len(pending) < len(writes) && mode == AllOrNothing {
+		return batchFailed(errs), ErrBatchFailed
+	}
+	if len(pending) == 0 {
+		return errs, nil
+	}
+
+	write := func(rw *ReadWriterPG) error {
+		if rw.concurrencyMode == PessimisticLocking {
+			accountIDs := batchAccountIDs(writes, pending)
+			if err := rw.lockAccounts(ctx, accountIDs); err != nil {
+				return err
+			}
+
+			checked, err := rw.checkBatchBalances(ctx, writes, pending, accountIDs, errs)
+			if err != nil {
+				return err
+			}
+			if len(checked) < len(pending) && mode == AllOrNothing {
+				return ErrBatchFailed
+			}
+			if pending = checked; len(pending) == 0 {
+				return nil
+			}
+		}
+
+		failed, err := rw.inSavepoint(ctx, "peluciopg_batch", func() error {
+			return rw.insertBatch(ctx, writes, pending)
+		})
+		if err != nil {
+			return err
+		}
+
+		posted := pending
+		if failed != nil {
+			// find out which writes fail by posting them one by one
+			posted, err = rw.insertBatchItems(ctx, writes, pending, errs)
+			if err != nil {
+				return err
+			}
+			if len(posted) < len(pending) && mode == AllOrNothing {
+				return ErrBatchFailed
+			}
+		}
+
+		return rw.settleBatch(ctx, writes, posted)
+	}
+
+	var err error
+	if rw.tx != nil {
+		// the caller's transaction outlives a failed batch, so the writes and
+		// locks of the batch are rolled back to where it started
+		var failed error
+		if failed, err = rw.inSavepoint(ctx, "peluciopg_batch_all", func() error { return write(rw) }); err == nil {
+			err = failed
+		}
+	} else {
+		err = rw.RunInTx(ctx, write)
+	}
+	if errors.Is(err, ErrBatchFailed) {
+		return batchFailed(errs), err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return errs, nil
+}
+
+// checkBatchBalances applies the writes at indexes, in order, to the balances
+// of their locked accounts and returns the indexes of the ones that fit,
+// setting the error of the others. A write failing later on leaves the
+// following ones checked against balances a little lower than posted.
+func (rw *ReadWriterPG) checkBatchBalances(ctx context.Context, writes []TransactionWrite, indexes []int, accountIDs []uuid.UUID, errs []error) ([]int, error) {
+	balances, err := rw.readLockedBalances(ctx, accountIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	checked := make([]int, 0, len(indexes))
+	for _, index := range indexes {
+		if errs[index] = balances.apply(writes[index].Transaction); errs[index] == nil {
+			checked = append(checked, index)
+		}
+	}
+
+	return checked, nil
+}
+
+// batchFailed sets ErrBatchFailed as the error of the writes of a failed
+// AllOrNothing batch that did not fail themselves.
+func batchFailed(errs []error) []error {
+	for i, err := range errs {
+		if err == nil {
+			errs[i] = ErrBatchFailed
+		}
+	}
+
+	return errs
+}
+
+// insertBatch inserts the transactions and entries of the writes at indexes
+// and bumps the version of their accounts, in id order.
+func (rw *ReadWriterPG) insertBatch(ctx context.Context, writes []TransactionWrite, indexes []int) error {
+	transactions := make([]*transaction, len(indexes))
+	accounts := map[uuid.UUID]*pelucio.Account{}
+	for i, index := range indexes {
+		transactions[i] = newTransactionFromPelucio(writes[index].Transaction)
+		for _, account := range writes[index].Accounts {
+			if _, ok := accounts[account.ID]; !ok {
+				accounts[account.ID] = account
+			}
+		}
+	}
+
+	if err := rw.insertTransactions(ctx, transactions); err != nil {
+		return err
+	}
+
+	ordered := make([]*pelucio.Account, 0, len(accounts))
+	for _, account := range accounts {
+		ordered = append(ordered, account)
+	}
+	slices.SortFunc(ordered, func(a, b *pelucio.Account) int {
+		return bytes.Compare(a.ID.Bytes(), b.ID.Bytes())
+	})
+	for _, account := range ordered {
+		if err := rw.bumpAccountVersion(ctx, account); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// insertBatchItems inserts the writes at indexes one by one, each in its own
+// savepoint, setting the errors of the ones that fail in errs. It returns the
+// indexes of the writes inserted.
+func (rw *ReadWriterPG) insertBatchItems(ctx context.Context, writes []TransactionWrite, indexes []int, errs []error) ([]int, error) {
+	posted := make([]int, 0, len(indexes))
+	bumped := map[uuid.UUID]bool{}
+	for _, index := range indexes {
+		bumping := []uuid.UUID{}
+		failed, err := rw.inSavepoint(ctx, "peluciopg_batch_item", func() error {
+			transactions := []*transaction{newTransactionFromPelucio(writes[index].Transaction)}
+			if err := rw.insertTransactions(ctx, transactions); err != nil {
+				return err
+			}
+
+			for _, account := range writes[index].Accounts {
+				if bumped[account.ID] || slices.Contains(bumping, account.ID) {
+					continue
+				}
+				if err := rw.bumpAccountVersion(ctx, account); err != nil {
+					return err
+				}
+				bumping = append(bumping, account.ID)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if failed != nil {
+			errs[index] = failed
+			continue
+		}
+
+		for _, id := range bumping {
+			bumped[id] = true
+		}
+		posted = append(posted, index)
+	}
+
+	return posted, nil
+}
+
+func (rw *ReadWriterPG) insertTransactions(ctx context.Context, transactions []*transaction) error {
+	err := insertChunks(ctx, rw, `
+		INSERT INTO `+rw.table("transactions")+` (id, external_id, description, metadata, created_at, executed_at)
+		VALUES (:id, :external_id, :description, :metadata, :created_at, :executed_at)
+	`, transactions)
+	if err != nil {
+		return err
+	}
+
+	entries := []*entry{}
+	for _, transaction := range transactions {
+		entries = append(entries, transaction.Entries...)
+	}
+
+	return insertChunks(ctx, rw, `
+		INSERT INTO `+rw.table("entries")+` (id, transaction_id, account_id, entry_side, account_side, amount, currency, created_at)
+		VALUES (:id, :transaction_id, :account_id, :entry_side, :account_side, :amount, :currency, :created_at)
+	`, entries)
+}
+
+// settleBatch increments the balances moved by the writes at indexes, once per
+// account and currency, and records their events and notifications.
+func (rw *ReadWriterPG) settleBatch(ctx context.Context, writes []TransactionWrite, indexes []int) error {
+	combined := &pelucio.Transaction{}
+	events := []*ledgerEventRow{}
+	payloads := []string{}
+	now := rw.now()
+	for _, index := range indexes {
+		transaction := writes[index].Transaction
+		combined.Entries = append(combined.Entries, transaction.Entries...)
+
+		deltas := balanceDeltas(transaction)
+		if rw.outbox {
+			rows, err := newLedgerEventRows(transaction, deltas, now)
+			if err != nil {
+				return err
+			}
+			events = append(events, rows...)
+		}
+		if rw.notifyChannel != "" {
+			payload, err := notificationPayload(transaction, deltas)
+			if err != nil {
+				return err
+			}
+			payloads = append(payloads, payload)
+		}
+	}
+
+	if err := rw.incrementBalances(ctx, balanceDeltas(combined)); err != nil {
+		return err
+	}
+	if err := rw.insertLedgerEvents(ctx, events); err != nil {
+		return err
+	}
+	if len(payloads) == 0 {
+		return nil
+	}
+
+	var discard []string
+	return rw.selectContext(ctx, rw.ext(), &discard, "SELECT pg_notify($1, payload) FROM unnest($2::text[]) AS payload",
+		rw.notifyChannel, pq.Array(payloads))
+}
+
+// inSavepoint runs fn in a savepoint of the current transaction, rolling back
+// to it when fn fails. The error of fn is returned apart from the one of
+// managing the savepoint, after which the transaction is unusable.
+func (rw *ReadWriterPG) inSavepoint(ctx context.Context, name string, fn func() error) (failed error, err error) {
+	if _, err := rw.execContext(ctx, rw.ext(), "SAVEPOINT "+name); err != nil {
+		return nil, err
+	}
+
+	if failed = fn(); failed != nil {
+		_, err = rw.execContext(ctx, rw.ext(), "ROLLBACK TO SAVEPOINT "+name)
+		return failed, err
+	}
+
+	_, err = rw.execContext(ctx, rw.ext(), "RELEASE SAVEPOINT "+name)
+	return nil, err
+}
+
+// batchAccountIDs returns the accounts written along with the writes at
+// indexes and the ones their entries move.
+func batchAccountIDs(writes []TransactionWrite, indexes []int) []uuid.UUID {
+	seen := map[uuid.UUID]bool{}
+	accountIDs := []uuid.UUID{}
+	for _, index := range indexes {
+		accountIDs = appendAccountIDs(accountIDs, seen, writes[index].Transaction, writes[index].Accounts)
+	}
+
+	return accountIDs
+}
+
+// checkBalanced fails the way the entries_balanced trigger would when the
+// debits and credits of transaction differ in some currency.
+func checkBalanced(transaction *pelucio.Transaction) error {
+	sums := map[pelucio.Currency]*big.Int{}
+	currencies := []pelucio.Currency{}
+	for _, entry := range transaction.Entries {
+		sum, ok := sums[entry.Currency]
+		if !ok {
+			sum = new(big.Int)
+			sums[entry.Currency] = sum
+			currencies = append(currencies, entry.Currency)
+		}
+		if entry.Amount == nil {
+			continue
+		}
+		if entry.EntrySide == pelucio.Debit {
+			sum.Add(sum, entry.Amount)
+		} else {
+			sum.Sub(sum, entry.Amount)
+		}
+	}
+
+	for _, currency := range currencies {
+		if sums[currency].Sign() != 0 {
+			return &UnbalancedTransactionError{
+				TransactionID: transaction.ID,
+				Message:       fmt.Sprintf("transaction %s is not balanced in currency %s", transaction.ID, currency),
+			}
+		}
+	}
+
+	return nil
+}
+
+// insertChunks runs the multi-row insert query over rows, batchInsertSize
+// rows at a time.
+func insertChunks[T any](ctx context.Context, rw *ReadWriterPG, query string, rows []T) error {
+	for start := 0; start < len(rows); start += batchInsertSize {
+		end := min(start+batchInsertSize, len(rows))
+		if _, err := rw.namedExecContext(ctx, rw.ext(), query, rows[start:end]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,272 @@
+package peluciopg
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/devmalloni/pelucio"
+	"github.com/devmalloni/pelucio/x/xtime"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func newBatchWrites(n int) []TransactionWrite {
+	from := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Debit))
+	to := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Credit))
+
+	writes := make([]TransactionWrite, n)
+	for i := range writes {
+		writes[i] = TransactionWrite{
+			Transaction: pelucio.Deposit(fmt.Sprintf("external-%d", i), from.ID, to.ID, big.NewInt(int64(i+1)), "USD"),
+			Accounts:    []*pelucio.Account{from, to},
+		}
+	}
+
+	return writes
+}
+
+func TestWriteTransactions_AllOrNothing(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	writes := newBatchWrites(3)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SAVEPOINT peluciopg_batch").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO transactions (.+) VALUES (.+), (.+), (.+)").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("INSERT INTO entries").WillReturnResult(sqlmock.NewResult(0, 6))
+	// every account is bumped once, whatever the number of transactions
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("RELEASE SAVEPOINT peluciopg_batch").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO account_balances").
+		WithArgs(sqlmock.AnyArg(), "USD", "6", sqlmock.AnyArg(), "USD", "6").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	errs, err := db.WriteTransactions(context.Background(), writes, AllOrNothing)
+	assert.NoError(t, err)
+	assert.Equal(t, []error{nil, nil, nil}, errs)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWriteTransactions_AllOrNothing_Unbalanced(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	writes := newBatchWrites(2)
+	writes[1].Transaction.Entries[0].Amount = big.NewInt(99)
+
+	errs, err := db.WriteTransactions(context.Background(), writes, AllOrNothing)
+	assert.ErrorIs(t, err, ErrBatchFailed)
+	assert.ErrorIs(t, errs[0], ErrBatchFailed)
+	assert.ErrorIs(t, errs[1], ErrUnbalancedTransaction)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWriteTransactions_AllOrNothing_RollsBackOnFailedWrite(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	writes := newBatchWrites(2)
+	duplicate := &pq.Error{Code: SQLStateUniqueViolation, Constraint: "transactions_external_id_key"}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SAVEPOINT peluciopg_batch").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO transactions").WillReturnError(duplicate)
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT peluciopg_batch").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SAVEPOINT peluciopg_batch_item").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO transactions").WillReturnError(duplicate)
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT peluciopg_batch_item").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SAVEPOINT peluciopg_batch_item").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO entries").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("RELEASE SAVEPOINT peluciopg_batch_item").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	errs, err := db.WriteTransactions(context.Background(), writes, AllOrNothing)
+	assert.ErrorIs(t, err, ErrBatchFailed)
+	assert.ErrorIs(t, errs[0], pelucio.ErrExternalIDAlreadyInUse)
+	// posted in the savepoint, then rolled back with the batch
+	assert.ErrorIs(t, errs[1], ErrBatchFailed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWriteTransactions_BestEffort(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	WithOutbox()(db)
+
+	writes := newBatchWrites(3)
+	writes[0].Transaction.Entries[1].Amount = big.NewInt(0)
+	conflict := sqlmock.NewResult(0, 0)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SAVEPOINT peluciopg_batch").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO entries").WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(conflict)
+	mock.ExpectQuery("SELECT version FROM accounts").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(42))
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT peluciopg_batch").WillReturnResult(sqlmock.NewResult(0, 0))
+	for range 2 {
+		mock.ExpectExec("SAVEPOINT peluciopg_batch_item").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO entries").WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec("UPDATE accounts").WillReturnResult(conflict)
+		mock.ExpectQuery("SELECT version FROM accounts").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(42))
+		mock.ExpectExec("ROLLBACK TO SAVEPOINT peluciopg_batch_item").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectCommit()
+
+	errs, err := db.WriteTransactions(context.Background(), writes, BestEffort)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, errs[0], ErrUnbalancedTransaction)
+	assert.ErrorIs(t, errs[1], ErrVersionConflict)
+	assert.ErrorIs(t, errs[2], ErrVersionConflict)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWriteTransactions_BestEffort_SettlesPostedWrites(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	WithOutbox()(db)
+	WithNotifications("")(db)
+
+	writes := newBatchWrites(2)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SAVEPOINT peluciopg_batch").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO transactions").WillReturnError(&pq.Error{Code: SQLStateUniqueViolation})
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT peluciopg_batch").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SAVEPOINT peluciopg_batch_item").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO entries").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("RELEASE SAVEPOINT peluciopg_batch_item").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SAVEPOINT peluciopg_batch_item").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO transactions").WillReturnError(&pq.Error{Code: SQLStateUniqueViolation})
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT peluciopg_batch_item").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO account_balances").
+		WithArgs(sqlmock.AnyArg(), "USD", "1", sqlmock.AnyArg(), "USD", "1").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO ledger_events").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectQuery("SELECT pg_notify\\(\\$1, payload\\) FROM unnest\\(\\$2::text\\[\\]\\) AS payload").
+		WillReturnRows(sqlmock.NewRows([]string{"pg_notify"}).AddRow(""))
+	mock.ExpectCommit()
+
+	errs, err := db.WriteTransactions(context.Background(), writes, BestEffort)
+	assert.NoError(t, err)
+	assert.NoError(t, errs[0])
+	assert.ErrorIs(t, errs[1], ErrAlreadyExists)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWriteTransactions_PessimisticLocking(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	WithConcurrencyMode(PessimisticLocking)(db)
+
+	writes := newBatchWrites(2)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("ORDER BY id FOR UPDATE").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(writes[0].Accounts[0].ID).AddRow(writes[0].Accounts[1].ID))
+	mock.ExpectQuery("SELECT account_id, currency, amount FROM account_balances").
+		WillReturnRows(sqlmock.NewRows([]string{"account_id", "currency", "amount"}))
+	mock.ExpectExec("SAVEPOINT peluciopg_batch").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO entries").WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectExec("UPDATE accounts (.+) WHERE id = \\$3$").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE accounts (.+) WHERE id = \\$3$").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("RELEASE SAVEPOINT peluciopg_batch").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO account_balances").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	errs, err := db.WriteTransactions(context.Background(), writes, AllOrNothing)
+	assert.NoError(t, err)
+	assert.Equal(t, []error{nil, nil}, errs)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWriteTransactions_PessimisticLocking_InsufficientLockedBalance(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	WithConcurrencyMode(PessimisticLocking)(db)
+
+	bank := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Debit))
+	wallet := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Credit))
+	writes := make([]TransactionWrite, 3)
+	for i := range writes {
+		writes[i] = TransactionWrite{
+			Transaction: pelucio.Withdraw(fmt.Sprintf("external-%d", i), bank.ID, wallet.ID, big.NewInt(40), "USD"),
+			Accounts:    []*pelucio.Account{bank, wallet},
+		}
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("ORDER BY id FOR UPDATE").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(bank.ID).AddRow(wallet.ID))
+	// room for two withdrawals of 40, not three
+	mock.ExpectQuery("SELECT account_id, currency, amount FROM account_balances").
+		WillReturnRows(sqlmock.NewRows([]string{"account_id", "currency", "amount"}).
+			AddRow(bank.ID, "USD", "100").
+			AddRow(wallet.ID, "USD", "100"))
+	mock.ExpectExec("SAVEPOINT peluciopg_batch").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO transactions (.+) VALUES (.+), (.+)").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO entries").WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("RELEASE SAVEPOINT peluciopg_batch").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO account_balances").
+		WithArgs(sqlmock.AnyArg(), "USD", "-80", sqlmock.AnyArg(), "USD", "-80").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	errs, err := db.WriteTransactions(context.Background(), writes, BestEffort)
+	assert.NoError(t, err)
+	assert.NoError(t, errs[0])
+	assert.NoError(t, errs[1])
+	assert.ErrorIs(t, errs[2], pelucio.ErrInsufficientBalance)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWriteTransactions_AllOrNothing_WithTxRollsBackToBatchStart(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	writes := newBatchWrites(2)
+	duplicate := &pq.Error{Code: SQLStateUniqueViolation, Constraint: "transactions_external_id_key"}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("^SAVEPOINT peluciopg_batch_all$").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("^SAVEPOINT peluciopg_batch$").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO transactions").WillReturnError(duplicate)
+	mock.ExpectExec("^ROLLBACK TO SAVEPOINT peluciopg_batch$").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("^SAVEPOINT peluciopg_batch_item$").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO entries").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("^RELEASE SAVEPOINT peluciopg_batch_item$").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("^SAVEPOINT peluciopg_batch_item$").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO transactions").WillReturnError(duplicate)
+	mock.ExpectExec("^ROLLBACK TO SAVEPOINT peluciopg_batch_item$").WillReturnResult(sqlmock.NewResult(0, 0))
+	// the first write, posted in the caller's transaction, is undone with it
+	mock.ExpectExec("^ROLLBACK TO SAVEPOINT peluciopg_batch_all$").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	tx, err := db.DB.Beginx()
+	assert.NoError(t, err)
+
+	errs, err := db.WithTx(tx).WriteTransactions(context.Background(), writes, AllOrNothing)
+	assert.ErrorIs(t, err, ErrBatchFailed)
+	assert.ErrorIs(t, errs[0], ErrBatchFailed)
+	assert.ErrorIs(t, errs[1], pelucio.ErrExternalIDAlreadyInUse)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,186 @@
+package peluciopg
+
+import (
+	"context"
+	"maps"
+	"math/big"
+
+	"github.com/devmalloni/pelucio"
+	"github.com/devmalloni/pelucio/x/xuuid"
+	"github.com/gofrs/uuid/v5"
+	"github.com/lib/pq"
+)
+
+// ConcurrencyMode is how concurrent writes on the same accounts are kept from
+// overwriting each other.
+type ConcurrencyMode int
+
+const (
+	// OptimisticLocking updates an account only if its version is still the
+	// one it was read with, failing with a VersionConflictError otherwise.
+	OptimisticLocking ConcurrencyMode = iota
+	// PessimisticLocking locks the accounts a transaction moves before
+	// writing it, so concurrent writers wait for each other instead of
+	// conflicting. Account versions are bumped but not compared: the entries
+	// are checked again against the balances under the lock, failing with
+	// pelucio.ErrInsufficientBalance when they would overdraw one.
+	PessimisticLocking
+)
+
+// lockTransactionAccounts locks the accounts written along with transaction
+// and the ones its entries move, when in PessimisticLocking mode. Writers call
+// it before inserting the transaction, so every writer takes its locks in the
+// same id order.
+func (rw *ReadWriterPG) lockTransactionAccounts(ctx context.Context, transaction *pelucio.Transaction, accounts []*pelucio.Account) error {
+	if rw.concurrencyMode != PessimisticLocking {
+		return nil
+	}
+
+	accountIDs := appendAccountIDs(nil, map[uuid.UUID]bool{}, transaction, accounts)
+	return rw.lockAccounts(ctx, accountIDs)
+}
+
+// lockAccounts takes the row locks of accounts in id order, waiting for the
+// in-flight writes that hold any of them.
+func (rw *ReadWriterPG) lockAccounts(ctx context.Context, accountIDs []uuid.UUID) error {
+	var locked []uuid.UUID
+	return rw.selectContext(ctx, rw.ext(), &locked, `
+		SELECT id FROM `+rw.table("accounts")+`
+		WHERE id = ANY($1::uuid[])
+		ORDER BY id
+		FOR UPDATE
+	`, pq.Array(xuuid.ToStrings(accountIDs...)))
+}
+
+// lockBalances takes the row locks of the stored balances of accounts,
+// waiting for the in-flight increments of any of them.
+func (rw *ReadWriterPG) lockBalances(ctx context.Context, accountIDs []uuid.UUID) error {
+	var locked []uuid.UUID
+	return rw.selectContext(ctx, rw.ext(), &locked, `
+		SELECT account_id FROM `+rw.table("account_balances")+`
+		WHERE account_id = ANY($1::uuid[])
+		ORDER BY account_id, currency
+		FOR UPDATE
+	`, pq.Array(xuuid.ToStrings(accountIDs...)))
+}
+
+// appendAccountIDs appends to accountIDs the accounts written along with
+// transaction and the ones its entries move, skipping the ones seen.
+func appendAccountIDs(accountIDs []uuid.UUID, seen map[uuid.UUID]bool, transaction *pelucio.Transaction, accounts []*pelucio.Account) []uuid.UUID {
+	add := func(id uuid.UUID) {
+		if !seen[id] {
+			seen[id] = true
+			accountIDs = append(accountIDs, id)
+		}
+	}
+	for _, account := range accounts {
+		add(account.ID)
+	}
+	for _, entry := range transaction.Entries {
+		add(entry.AccountID)
+	}
+
+	return accountIDs
+}
+
+// lockedBalances are the balances of accounts locked by the current
+// transaction.
+type lockedBalances map[uuid.UUID]pelucio.Balance
+
+// readLockedBalances reads the balances of accounts, which must be locked so
+// they can't change until the transaction ends.
+func (rw *ReadWriterPG) readLockedBalances(ctx context.Context, accountIDs []uuid.UUID) (lockedBalances, error) {
+	rows := []*balanceDelta{}
+	err := rw.selectContext(ctx, rw.ext(), &rows, `
+		SELECT account_id, currency, amount
+		FROM `+rw.table("account_balances")+`
+		WHERE account_id = ANY($1::uuid[])
+	`, pq.Array(xuuid.ToStrings(accountIDs...)))
+	if err != nil {
+		return nil, err
+	}
+
+	balances := lockedBalances{}
+	for _, row := range rows {
+		if balances[row.AccountID] == nil {
+			balances[row.AccountID] = pelucio.Balance{}
+		}
+		balances[row.AccountID][row.Currency] = row.Amount.Amount
+	}
+
+	return balances, nil
+}
+
+// apply applies the entries of transaction to the balances, failing with
+// pelucio.ErrInsufficientBalance when one would go below zero. Balances are
+// left untouched when it fails.
+func (b lockedBalances) apply(transaction *pelucio.Transaction) error {
+	applied := map[uuid.UUID]pelucio.Balance{}
+	for _, entry := range transaction.Entries {
+		balance, ok := applied[entry.AccountID]
+		if !ok {
+			balance = pelucio.Balance{}
+			for currency, amount := range b[entry.AccountID] {
+				balance[currency] = new(big.Int).Set(amount)
+			}
+			applied[entry.AccountID] = balance
+		}
+
+		if err := entry.Apply(balance); err != nil {
+			return err
+		}
+	}
+
+	maps.Copy(b, applied)
+	return nil
+}
+
+// checkLockedBalances checks the entries of transaction against the balances
+// of its accounts under the lock, when in PessimisticLocking mode. pelucio
+// checked them against balances read before the lock, which concurrent
+// writes may have spent since.
+func (rw *ReadWriterPG) checkLockedBalances(ctx context.Context, transaction *pelucio.Transaction) error {
+	if rw.concurrencyMode != PessimisticLocking {
+		return nil
+	}
+
+	balances, err := rw.readLockedBalances(ctx, appendAccountIDs(nil, map[uuid.UUID]bool{}, transaction, nil))
+	if err != nil {
+		return err
+	}
+
+	return balances.apply(transaction)
+}
+
+// bumpAccountVersion gives account a new version. In OptimisticLocking mode
+// the update only applies to the version account was read with.
+func (rw *ReadWriterPG) bumpAccountVersion(ctx context.Context, account *pelucio.Account) error {
+	m := map[string]interface{}{
+		"id":          account.ID,
+		"version":     account.Version,
+		"updated_at":  account.UpdatedAt,
+		"new_version": rw.now().UnixNano(),
+	}
+	query := `
+		UPDATE ` + rw.table("accounts") + ` SET version = :new_version,
+							updated_at = :updated_at
+		WHERE id = :id`
+	if rw.concurrencyMode != PessimisticLocking {
+		query += " AND version = :version"
+	}
+
+	res, err := rw.namedExecContext(ctx, rw.ext(), query, m)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected != 1 {
+		return rw.versionConflict(ctx, account.ID, account.Version)
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package peluciopg
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/devmalloni/pelucio"
+	"github.com/devmalloni/pelucio/x/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteTransaction_PessimisticLocking(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	WithConcurrencyMode(PessimisticLocking)(db)
+
+	firstAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Debit))
+	secondAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Credit))
+	// versions the accounts no longer have are not compared
+	firstAccount.Version, secondAccount.Version = 1, 1
+	transaction := pelucio.Deposit("external", firstAccount.ID, secondAccount.ID, big.NewInt(100), "USD")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM accounts WHERE id = ANY\\(\\$1::uuid\\[\\]\\) ORDER BY id FOR UPDATE").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(firstAccount.ID).AddRow(secondAccount.ID))
+	mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT account_id, currency, amount FROM account_balances WHERE account_id = ANY").
+		WillReturnRows(sqlmock.NewRows([]string{"account_id", "currency", "amount"}))
+	mock.ExpectExec("INSERT INTO entries").WillReturnResult(sqlmock.NewResult(1, 2))
+	mock.ExpectExec("UPDATE accounts SET version = \\$1, updated_at = \\$2 WHERE id = \\$3$").
+		WithArgs(sqlmock.AnyArg(), firstAccount.UpdatedAt, firstAccount.ID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE accounts SET version = \\$1, updated_at = \\$2 WHERE id = \\$3$").
+		WithArgs(sqlmock.AnyArg(), secondAccount.UpdatedAt, secondAccount.ID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO account_balances").WillReturnResult(sqlmock.NewResult(1, 2))
+	mock.ExpectCommit()
+
+	err := db.WriteTransaction(context.Background(), transaction, firstAccount, secondAccount)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWriteTransaction_PessimisticLocking_AccountNotFound(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	WithConcurrencyMode(PessimisticLocking)(db)
+
+	firstAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Debit))
+	secondAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Credit))
+	transaction := pelucio.Deposit("external", firstAccount.ID, secondAccount.ID, big.NewInt(100), "USD")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FOR UPDATE").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(secondAccount.ID))
+	mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("FROM account_balances").WillReturnRows(sqlmock.NewRows([]string{"account_id", "currency", "amount"}))
+	mock.ExpectExec("INSERT INTO entries").WillReturnResult(sqlmock.NewResult(1, 2))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version FROM accounts").WithArgs(firstAccount.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}))
+	mock.ExpectRollback()
+
+	err := db.WriteTransaction(context.Background(), transaction, firstAccount, secondAccount)
+	assert.ErrorIs(t, err, pelucio.ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWriteTransaction_PessimisticLocking_InsufficientLockedBalance(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	WithConcurrencyMode(PessimisticLocking)(db)
+
+	bank := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Debit))
+	wallet := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Credit))
+	// the caller read 100 on both, then a concurrent withdrawal of 80 committed
+	bank.Balance = pelucio.Balance{"USD": big.NewInt(100)}
+	wallet.Balance = pelucio.Balance{"USD": big.NewInt(100)}
+	transaction := pelucio.Withdraw("external", bank.ID, wallet.ID, big.NewInt(80), "USD")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FOR UPDATE").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(bank.ID).AddRow(wallet.ID))
+	mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT account_id, currency, amount FROM account_balances").
+		WillReturnRows(sqlmock.NewRows([]string{"account_id", "currency", "amount"}).
+			AddRow(bank.ID, "USD", "20").
+			AddRow(wallet.ID, "USD", "20"))
+	mock.ExpectRollback()
+
+	err := db.WriteTransaction(context.Background(), transaction, bank, wallet)
+	assert.ErrorIs(t, err, pelucio.ErrInsufficientBalance)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
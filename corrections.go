@@ -0,0 +1,79 @@
+package peluciopg
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/devmalloni/pelucio/x/xuuid"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrImmutableLedger   = errors.New("ledger entries and transactions are append-only")
+	ErrInvalidCorrection = errors.New("ledger correction requires who performs it and why")
+)
+
+// LedgerCorrection is the audit record of an administrative change to
+// entries or transactions. Every row changed during it is recorded in
+// ledger_mutations with its previous and new content.
+type LedgerCorrection struct {
+	ID          uuid.UUID `json:"id" db:"id"`
+	PerformedBy string    `json:"performed_by" db:"performed_by"`
+	Reason      string    `json:"reason" db:"reason"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// CorrectLedger is the escape hatch for administrative fixes of entries and
+// transactions, which are otherwise append-only. fn runs inside a database
+// transaction where UPDATE and DELETE on those tables are allowed and
+// audited under the returned LedgerCorrection. Entries must still balance
+// when the transaction commits.
+func (rw *ReadWriterPG) CorrectLedger(ctx context.Context, performedBy, reason string, fn func(tx *sqlx.Tx) error) (*LedgerCorrection, error) {
+	if performedBy == "" || reason == "" {
+		return nil, ErrInvalidCorrection
+	}
+
+	correction := &LedgerCorrection{
+		ID:          xuuid.New(),
+		PerformedBy: performedBy,
+		Reason:      reason,
+		CreatedAt:   rw.now(),
+	}
+
+	err := rw.RunInTx(ctx, func(rw *ReadWriterPG) error {
+		_, err := rw.namedExecContext(ctx, rw.ext(), `
+			INSERT INTO `+rw.table("ledger_corrections")+` (id, performed_by, reason, created_at)
+			VALUES (:id, :performed_by, :reason, :created_at)
+		`, correction)
+		if err != nil {
+			return err
+		}
+
+		if err := rw.setCorrectionID(ctx, correction.ID.String()); err != nil {
+			return err
+		}
+
+		// the setting is transaction scoped, but a caller-owned transaction
+		// may keep going after the correction, failed or not
+		if err := fn(rw.tx); err != nil {
+			// fails too when fn left the transaction aborted, which the
+			// caller can then only roll back
+			_ = rw.setCorrectionID(ctx, "")
+			return translateError(err)
+		}
+
+		return rw.setCorrectionID(ctx, "")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return correction, nil
+}
+
+func (rw *ReadWriterPG) setCorrectionID(ctx context.Context, correctionID string) error {
+	var previous string
+	return rw.getContext(ctx, rw.ext(), &previous, "SELECT set_config('peluciopg.correction_id', $1, true)", correctionID)
+}
@@ -0,0 +1,83 @@
+package peluciopg
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/devmalloni/pelucio/x/xuuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCorrectLedger(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	entryID := xuuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO ledger_corrections").
+		WithArgs(sqlmock.AnyArg(), "ops@example.com", "wrong currency", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT set_config\\('peluciopg.correction_id', \\$1, true\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"set_config"}).AddRow("id"))
+	mock.ExpectExec("UPDATE entries SET currency").
+		WithArgs("BRL", entryID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT set_config\\('peluciopg.correction_id', \\$1, true\\)").
+		WithArgs("").
+		WillReturnRows(sqlmock.NewRows([]string{"set_config"}).AddRow(""))
+	mock.ExpectCommit()
+
+	correction, err := db.CorrectLedger(context.Background(), "ops@example.com", "wrong currency", func(tx *sqlx.Tx) error {
+		_, err := tx.Exec("UPDATE entries SET currency = $1 WHERE id = $2", "BRL", entryID)
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "wrong currency", correction.Reason)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCorrectLedger_WithTxResetsOnFailure(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	failed := errors.New("correction failed")
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO ledger_corrections").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT set_config\\('peluciopg.correction_id', \\$1, true\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"set_config"}).AddRow("id"))
+	// later writes of the caller's transaction are no corrections
+	mock.ExpectQuery("SELECT set_config\\('peluciopg.correction_id', \\$1, true\\)").
+		WithArgs("").
+		WillReturnRows(sqlmock.NewRows([]string{"set_config"}).AddRow(""))
+
+	tx, err := db.DB.Beginx()
+	assert.NoError(t, err)
+
+	_, err = db.WithTx(tx).CorrectLedger(context.Background(), "ops@example.com", "wrong currency", func(tx *sqlx.Tx) error {
+		return failed
+	})
+	assert.ErrorIs(t, err, failed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCorrectLedger_RequiresReason(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	_, err := db.CorrectLedger(context.Background(), "ops@example.com", "", func(tx *sqlx.Tx) error {
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrInvalidCorrection)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTranslateError_ImmutableLedger(t *testing.T) {
+	err := translateError(&pq.Error{Code: SQLStateImmutableLedger, Message: "DELETE on entries is not allowed"})
+	assert.ErrorIs(t, err, ErrImmutableLedger)
+}
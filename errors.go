@@ -0,0 +1,145 @@
+package peluciopg
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/devmalloni/pelucio"
+	"github.com/gofrs/uuid/v5"
+	"github.com/lib/pq"
+)
+
+const (
+	SQLStateForeignKeyViolation = "23503"
+	SQLStateUniqueViolation     = "23505"
+	SQLStateCheckViolation      = "23514"
+
+	// SQLStateUnbalancedTransaction is raised by the entries_balanced trigger
+	// when the entries of a transaction do not balance per currency.
+	SQLStateUnbalancedTransaction = "PL001"
+
+	// SQLStateImmutableLedger is raised when entries or transactions are
+	// changed outside of a ledger correction.
+	SQLStateImmutableLedger = "PL002"
+)
+
+var (
+	ErrVersionConflict     = errors.New("account version conflict")
+	ErrAlreadyExists       = errors.New("record already exists")
+	ErrConstraintViolation = errors.New("constraint violation")
+
+	ErrUnbalancedTransaction = errors.New("transaction entries are not balanced")
+)
+
+// uniqueViolations maps unique constraints to the error pelucio expects when
+// they fire. Unique violations not listed here become ErrAlreadyExists.
+var uniqueViolations = map[string]error{
+	"accounts_external_id_key":     pelucio.ErrExternalIDAlreadyInUse,
+	"transactions_external_id_key": pelucio.ErrExternalIDAlreadyInUse,
+}
+
+// foreignKeyViolations maps foreign keys to the error reported when a row
+// references a record that does not exist. Foreign keys not listed here
+// become pelucio.ErrNotFound.
+var foreignKeyViolations = map[string]error{
+	"entries_accounts":     pelucio.ErrAccountNotFound,
+	"entries_transactions": pelucio.ErrNotFound,
+}
+
+// VersionConflictError is returned when an account was changed by someone else
+// since it was read. Callers should reload the account and retry.
+// It matches ErrVersionConflict with errors.Is.
+type VersionConflictError struct {
+	AccountID       uuid.UUID
+	ExpectedVersion int64
+	ActualVersion   int64
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("%s: account %s expected version %d, found %d",
+		ErrVersionConflict, e.AccountID, e.ExpectedVersion, e.ActualVersion)
+}
+
+func (e *VersionConflictError) Is(target error) bool {
+	return target == ErrVersionConflict
+}
+
+// UnbalancedTransactionError is returned when committing entries whose debits
+// and credits differ in some currency. It matches ErrUnbalancedTransaction
+// with errors.Is.
+type UnbalancedTransactionError struct {
+	TransactionID uuid.UUID
+	Message       string
+}
+
+func (e *UnbalancedTransactionError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrUnbalancedTransaction, e.Message)
+}
+
+func (e *UnbalancedTransactionError) Is(target error) bool {
+	return target == ErrUnbalancedTransaction
+}
+
+// versionConflict explains why a versioned write on an account touched no rows:
+// either the account does not exist or its version moved on.
+func (rw *ReadWriterPG) versionConflict(ctx context.Context, accountID uuid.UUID, expectedVersion int64) error {
+	var actualVersion int64
+	err := rw.getContext(ctx, rw.ext(), &actualVersion, "SELECT version FROM "+rw.table("accounts")+" WHERE id = $1", accountID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return pelucio.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return &VersionConflictError{
+		AccountID:       accountID,
+		ExpectedVersion: expectedVersion,
+		ActualVersion:   actualVersion,
+	}
+}
+
+// TranslateError maps the errors Postgres reports for the constraints of the
+// ledger to the errors of pelucio and of this package, as the methods of
+// ReadWriterPG do. Callers owning the transaction given to WithTx need it for
+// the error of their Commit, where the deferred entries_balanced check fires.
+func TranslateError(err error) error {
+	return translateError(err)
+}
+
+// translateError maps constraint violations reported by Postgres to the
+// errors of pelucio and of this package. Sentinel errors are returned as is,
+// so callers comparing with == keep working. Any other error is returned
+// untouched.
+func translateError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+
+	switch pqErr.Code {
+	case SQLStateUniqueViolation:
+		if mapped, ok := uniqueViolations[pqErr.Constraint]; ok {
+			return mapped
+		}
+		return ErrAlreadyExists
+	case SQLStateForeignKeyViolation:
+		if mapped, ok := foreignKeyViolations[pqErr.Constraint]; ok {
+			return mapped
+		}
+		return pelucio.ErrNotFound
+	case SQLStateCheckViolation:
+		return fmt.Errorf("%w: %s", ErrConstraintViolation, pqErr.Constraint)
+	case SQLStateImmutableLedger:
+		return fmt.Errorf("%w: %s", ErrImmutableLedger, pqErr.Message)
+	case SQLStateUnbalancedTransaction:
+		return &UnbalancedTransactionError{
+			TransactionID: uuid.FromStringOrNil(pqErr.Detail),
+			Message:       pqErr.Message,
+		}
+	}
+
+	return err
+}
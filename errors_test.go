@@ -0,0 +1,186 @@
+package peluciopg
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/devmalloni/pelucio"
+	"github.com/devmalloni/pelucio/x/xtime"
+	"github.com/devmalloni/pelucio/x/xuuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteTransaction_VersionConflict(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	firstAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Debit))
+	firstAccount.Version = 10
+	secondAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Credit))
+	transaction := pelucio.Deposit("external", firstAccount.ID, secondAccount.ID, big.NewInt(100), "USD")
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO entries").WillReturnResult(sqlmock.NewResult(1, 2))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version FROM accounts WHERE id = \\$1").
+		WithArgs(firstAccount.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(int64(11)))
+	mock.ExpectRollback()
+
+	err := db.WriteTransaction(context.Background(), transaction, firstAccount, secondAccount)
+	assert.ErrorIs(t, err, ErrVersionConflict)
+
+	var conflict *VersionConflictError
+	assert.ErrorAs(t, err, &conflict)
+	assert.Equal(t, firstAccount.ID, conflict.AccountID)
+	assert.Equal(t, int64(10), conflict.ExpectedVersion)
+	assert.Equal(t, int64(11), conflict.ActualVersion)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWriteTransaction_MissingAccount(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	firstAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Debit))
+	secondAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Credit))
+	transaction := pelucio.Deposit("external", firstAccount.ID, secondAccount.ID, big.NewInt(100), "USD")
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO entries").WillReturnResult(sqlmock.NewResult(1, 2))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version FROM accounts WHERE id = \\$1").
+		WithArgs(firstAccount.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}))
+	mock.ExpectRollback()
+
+	err := db.WriteTransaction(context.Background(), transaction, firstAccount, secondAccount)
+	assert.ErrorIs(t, err, pelucio.ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWriteAccount_UpsertVersionConflict(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	acc := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Debit))
+	acc.Version = 3
+
+	mock.ExpectQuery("INSERT INTO accounts AS accounts .* UPDATE SET .* WHERE accounts.version =").
+		WillReturnRows(sqlmock.NewRows([]string{"inserted"}))
+	mock.ExpectQuery("SELECT version FROM accounts WHERE id = \\$1").
+		WithArgs(acc.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(int64(4)))
+
+	err := db.WriteAccount(context.Background(), acc, true)
+	assert.ErrorIs(t, err, ErrVersionConflict)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWriteAccount_ExternalIDAlreadyInUse(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	acc := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Debit))
+
+	mock.ExpectExec("INSERT INTO accounts").
+		WillReturnError(&pq.Error{Code: SQLStateUniqueViolation, Constraint: "accounts_external_id_key"})
+
+	err := db.WriteAccount(context.Background(), acc, false)
+	assert.True(t, err == pelucio.ErrExternalIDAlreadyInUse)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWriteTransaction_TranslatesConstraintViolations(t *testing.T) {
+	cases := []struct {
+		name     string
+		pqErr    *pq.Error
+		expected error
+	}{
+		{"duplicated external id", &pq.Error{Code: SQLStateUniqueViolation, Constraint: "transactions_external_id_key"}, pelucio.ErrExternalIDAlreadyInUse},
+		{"duplicated id", &pq.Error{Code: SQLStateUniqueViolation, Constraint: "entries_pkey"}, ErrAlreadyExists},
+		{"unknown account", &pq.Error{Code: SQLStateForeignKeyViolation, Constraint: "entries_accounts"}, pelucio.ErrAccountNotFound},
+		{"check violation", &pq.Error{Code: SQLStateCheckViolation, Constraint: "entries_amount_check"}, ErrConstraintViolation},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db, mock, cleanup := setupMockDB(t)
+			defer cleanup()
+
+			firstAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Debit))
+			secondAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Credit))
+			transaction := pelucio.Deposit("external", firstAccount.ID, secondAccount.ID, big.NewInt(100), "USD")
+
+			mock.ExpectBegin()
+			mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("INSERT INTO entries").WillReturnError(c.pqErr)
+			mock.ExpectRollback()
+
+			err := db.WriteTransaction(context.Background(), transaction, firstAccount, secondAccount)
+			assert.ErrorIs(t, err, c.expected)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestWriteTransaction_UnbalancedOnCommit(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	firstAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Debit))
+	secondAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Credit))
+	transaction := pelucio.Deposit("external", firstAccount.ID, secondAccount.ID, big.NewInt(100), "USD")
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO entries").WillReturnResult(sqlmock.NewResult(1, 2))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO account_balances").WillReturnResult(sqlmock.NewResult(1, 2))
+	mock.ExpectCommit().WillReturnError(&pq.Error{
+		Code:       SQLStateUnbalancedTransaction,
+		Message:    "transaction is not balanced in currency USD",
+		Detail:     transaction.ID.String(),
+		Constraint: "entries_balanced",
+	})
+
+	err := db.WriteTransaction(context.Background(), transaction, firstAccount, secondAccount)
+	assert.ErrorIs(t, err, ErrUnbalancedTransaction)
+
+	var unbalanced *UnbalancedTransactionError
+	assert.ErrorAs(t, err, &unbalanced)
+	assert.Equal(t, transaction.ID, unbalanced.TransactionID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTranslateError_Commit(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	transactionID := xuuid.New()
+	mock.ExpectBegin()
+	mock.ExpectCommit().WillReturnError(&pq.Error{
+		Code:    SQLStateUnbalancedTransaction,
+		Message: "transaction is not balanced in currency USD",
+		Detail:  transactionID.String(),
+	})
+
+	tx, err := db.DB.Beginx()
+	assert.NoError(t, err)
+
+	// the deferred balance check only fires on the caller's commit
+	err = TranslateError(tx.Commit())
+	assert.ErrorIs(t, err, ErrUnbalancedTransaction)
+	var unbalanced *UnbalancedTransactionError
+	if assert.ErrorAs(t, err, &unbalanced) {
+		assert.Equal(t, transactionID, unbalanced.TransactionID)
+	}
+	assert.NoError(t, TranslateError(nil))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,265 @@
+package peluciopg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"time"
+
+	"github.com/devmalloni/pelucio"
+)
+
+type ExportFormat string
+
+const (
+	// ExportCSV writes a header line and a line per record. Metadata and
+	// balances are embedded as JSON text.
+	ExportCSV ExportFormat = "csv"
+	// ExportNDJSON writes a JSON object per line, with the keys of the CSV
+	// columns in the same order. Metadata and balances are embedded as JSON.
+	ExportNDJSON ExportFormat = "ndjson"
+)
+
+var ErrUnsupportedExport = errors.New("unsupported export")
+
+// The columns of each export. They only ever get appended to, so consumers
+// can rely on their order.
+var (
+	accountExportColumns     = []string{"id", "external_id", "name", "normal_side", "balance", "metadata", "version", "created_at", "updated_at", "deleted_at"}
+	transactionExportColumns = []string{"id", "external_id", "description", "metadata", "created_at", "executed_at"}
+	entryExportColumns       = []string{"id", "transaction_id", "account_id", "entry_side", "account_side", "amount", "currency", "created_at"}
+)
+
+// Export writes the accounts, transactions or entries matching filter to w,
+// oldest first, depending on whether filter is a pelucio.ReadAccountFilter,
+// ReadTransactionFilter or ReadEntryFilter. Records are read a page of
+// filter.Limit rows at a time, as with IterAccounts, and written as they
+// come, so memory stays flat whatever the size of the export.
+//
+// Amounts are exact decimal strings and timestamps are RFC 3339 in UTC. Empty
+// values are empty CSV fields and JSON nulls.
+func (rw *ReadWriterPG) Export(ctx context.Context, w io.Writer, format ExportFormat, filter any) error {
+	var enc exportEncoder
+	switch format {
+	case ExportCSV:
+		enc = &csvExportEncoder{w: csv.NewWriter(w)}
+	case ExportNDJSON:
+		enc = &ndjsonExportEncoder{w: bufio.NewWriter(w)}
+	default:
+		return fmt.Errorf("%w: format %q", ErrUnsupportedExport, format)
+	}
+
+	opts := PageOptions{SortKey: SortByCreatedAt, Order: Ascending}
+	switch filter := filter.(type) {
+	case pelucio.ReadAccountFilter:
+		return exportRecords(enc, accountExportColumns, rw.IterAccounts(ctx, filter, opts), accountExportValues)
+	case pelucio.ReadTransactionFilter:
+		// IterTransactions without the entries, which are exported apart
+		filter.Limit = iterPageSize(filter.Limit)
+		transactions := iterPages(ctx, filter.PaginationToken, func(token *string) (*Page[*pelucio.Transaction], error) {
+			filter.PaginationToken = token
+			return rw.readTransactionsPage(ctx, filter, opts)
+		})
+		return exportRecords(enc, transactionExportColumns, transactions, transactionExportValues)
+	case pelucio.ReadEntryFilter:
+		return exportRecords(enc, entryExportColumns, rw.IterEntries(ctx, filter, opts), entryExportValues)
+	default:
+		return fmt.Errorf("%w: filter %T", ErrUnsupportedExport, filter)
+	}
+}
+
+// exportRecords writes records with the values returned by values, which are
+// strings, JSON documents as json.RawMessage or nil, one per column.
+func exportRecords[T any](enc exportEncoder, columns []string, records iter.Seq2[T, error], values func(T) ([]interface{}, error)) error {
+	if err := enc.header(columns); err != nil {
+		return err
+	}
+
+	for record, err := range records {
+		if err != nil {
+			return err
+		}
+
+		row, err := values(record)
+		if err != nil {
+			return err
+		}
+		if err := enc.row(columns, row); err != nil {
+			return err
+		}
+	}
+
+	return enc.flush()
+}
+
+func accountExportValues(account *pelucio.Account) ([]interface{}, error) {
+	amounts := make(map[pelucio.Currency]string, len(account.Balance))
+	for currency, amount := range account.Balance {
+		if amount != nil {
+			amounts[currency] = amount.String()
+		}
+	}
+	balance, err := json.Marshal(amounts)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := exportJSON(account.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return []interface{}{
+		account.ID.String(),
+		account.ExternalID,
+		account.Name,
+		string(account.NormalSide),
+		json.RawMessage(balance),
+		metadata,
+		fmt.Sprint(account.Version),
+		exportTime(&account.CreatedAt),
+		exportTime(account.UpdatedAt),
+		exportTime(account.DeletedAt),
+	}, nil
+}
+
+func transactionExportValues(transaction *pelucio.Transaction) ([]interface{}, error) {
+	metadata, err := exportJSON(transaction.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return []interface{}{
+		transaction.ID.String(),
+		transaction.ExternalID,
+		transaction.Description,
+		metadata,
+		exportTime(&transaction.CreatedAt),
+		exportTime(transaction.ExecutedAt),
+	}, nil
+}
+
+func entryExportValues(entry *pelucio.Entry) ([]interface{}, error) {
+	var amount interface{}
+	if entry.Amount != nil {
+		amount = entry.Amount.String()
+	}
+
+	return []interface{}{
+		entry.ID.String(),
+		entry.TransactionID.String(),
+		entry.AccountID.String(),
+		string(entry.EntrySide),
+		string(entry.AccountSide),
+		amount,
+		string(entry.Currency),
+		exportTime(&entry.CreatedAt),
+	}, nil
+}
+
+// exportJSON compacts raw, so it fits on a line of NDJSON.
+func exportJSON(raw json.RawMessage) (interface{}, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, raw); err != nil {
+		return nil, err
+	}
+	return json.RawMessage(compacted.Bytes()), nil
+}
+
+func exportTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+type exportEncoder interface {
+	header(columns []string) error
+	row(columns []string, values []interface{}) error
+	flush() error
+}
+
+type csvExportEncoder struct {
+	w      *csv.Writer
+	record []string
+}
+
+func (e *csvExportEncoder) header(columns []string) error {
+	e.record = make([]string, len(columns))
+	return e.w.Write(columns)
+}
+
+func (e *csvExportEncoder) row(columns []string, values []interface{}) error {
+	for i, value := range values {
+		switch value := value.(type) {
+		case string:
+			e.record[i] = value
+		case json.RawMessage:
+			e.record[i] = string(value)
+		default:
+			e.record[i] = ""
+		}
+	}
+
+	return e.w.Write(e.record)
+}
+
+func (e *csvExportEncoder) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type ndjsonExportEncoder struct {
+	w *bufio.Writer
+}
+
+func (e *ndjsonExportEncoder) header(columns []string) error {
+	return nil
+}
+
+// row writes the object by hand to keep its keys in column order, where
+// encoding/json sorts map keys.
+func (e *ndjsonExportEncoder) row(columns []string, values []interface{}) error {
+	e.w.WriteByte('{')
+	for i, value := range values {
+		if i > 0 {
+			e.w.WriteByte(',')
+		}
+
+		key, err := json.Marshal(columns[i])
+		if err != nil {
+			return err
+		}
+		e.w.Write(key)
+		e.w.WriteByte(':')
+
+		switch value := value.(type) {
+		case string:
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			e.w.Write(encoded)
+		case json.RawMessage:
+			e.w.Write(value)
+		default:
+			e.w.WriteString("null")
+		}
+	}
+	// bufio.Writer keeps failing with the first error it met
+	_, err := e.w.WriteString("}\n")
+	return err
+}
+
+func (e *ndjsonExportEncoder) flush() error {
+	return e.w.Flush()
+}
@@ -0,0 +1,80 @@
+package peluciopg
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/devmalloni/pelucio"
+	"github.com/devmalloni/pelucio/x/xuuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExport_EntriesCSV(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	limit := uint(1)
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.FixedZone("BRT", -3*60*60))
+	ids := []string{xuuid.New().String(), xuuid.New().String()}
+	transactionID, accountID := xuuid.New().String(), xuuid.New().String()
+	columns := []string{"id", "transaction_id", "account_id", "entry_side", "account_side", "amount", "currency", "created_at"}
+
+	// exported a page at a time, oldest first
+	mock.ExpectQuery("SELECT \\* FROM entries AS entries ORDER BY entries.created_at ASC, entries.id ASC LIMIT \\$1").
+		WithArgs(limit + 1).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(ids[0], transactionID, accountID, pelucio.Debit, pelucio.Debit, "123456789012345678901234567890", "BRL", at).
+			AddRow(ids[1], transactionID, accountID, pelucio.Credit, pelucio.Debit, "7", "BRL", at))
+	mock.ExpectQuery("WHERE \\(entries.created_at, entries.id\\) > \\(\\$1, \\$2\\)").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(ids[1], transactionID, accountID, pelucio.Credit, pelucio.Debit, "7", "BRL", at))
+
+	var out bytes.Buffer
+	err := db.Export(context.Background(), &out, ExportCSV, pelucio.ReadEntryFilter{Limit: &limit})
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"id,transaction_id,account_id,entry_side,account_side,amount,currency,created_at",
+		ids[0] + "," + transactionID + "," + accountID + ",debit,debit,123456789012345678901234567890,BRL,2024-03-01T15:00:00Z",
+		ids[1] + "," + transactionID + "," + accountID + ",credit,debit,7,BRL,2024-03-01T15:00:00Z",
+		"",
+	}, "\n"), out.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExport_TransactionsNDJSON(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	first, second := xuuid.New().String(), xuuid.New().String()
+
+	mock.ExpectQuery("SELECT (.+) FROM transactions AS transactions ORDER BY transactions.created_at ASC").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "external_id", "description", "metadata", "created_at", "executed_at"}).
+			AddRow(first, "ext-1", "deposit", []byte(`{"b": 1, "a": {"c": "d"}}`), at, at).
+			AddRow(second, "ext-2", "say \"hi\"", nil, at, nil))
+	// entries are exported on their own, so they are not loaded
+
+	var out bytes.Buffer
+	err := db.Export(context.Background(), &out, ExportNDJSON, pelucio.ReadTransactionFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t,
+		`{"id":"`+first+`","external_id":"ext-1","description":"deposit","metadata":{"b":1,"a":{"c":"d"}},"created_at":"2024-03-01T12:00:00Z","executed_at":"2024-03-01T12:00:00Z"}`+"\n"+
+			`{"id":"`+second+`","external_id":"ext-2","description":"say \"hi\"","metadata":null,"created_at":"2024-03-01T12:00:00Z","executed_at":null}`+"\n",
+		out.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExport_Unsupported(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	var out bytes.Buffer
+	assert.ErrorIs(t, db.Export(context.Background(), &out, "xml", pelucio.ReadEntryFilter{}), ErrUnsupportedExport)
+	assert.ErrorIs(t, db.Export(context.Background(), &out, ExportCSV, &pelucio.ReadEntryFilter{}), ErrUnsupportedExport)
+	assert.Empty(t, out.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
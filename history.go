@@ -0,0 +1,209 @@
+package peluciopg
+
+import (
+	"context"
+	"database/sql"
+	"math/big"
+	"time"
+
+	"github.com/devmalloni/pelucio"
+	"github.com/devmalloni/pelucio/x/xuuid"
+	"github.com/gofrs/uuid/v5"
+	"github.com/lib/pq"
+)
+
+// ReadBalanceAt computes the balance of an account from its entries, as it was
+// right after every transaction executed up to at.
+func (rw *ReadWriterPG) ReadBalanceAt(ctx context.Context, accountID uuid.UUID, at time.Time) (pelucio.Balance, error) {
+	balances, err := rw.ReadBalancesAt(ctx, []uuid.UUID{accountID}, at)
+	if err != nil {
+		return nil, err
+	}
+
+	balance, ok := balances[accountID]
+	if !ok {
+		return nil, pelucio.ErrNotFound
+	}
+
+	return balance, nil
+}
+
+// ReadBalancesAt is the batch variant of ReadBalanceAt. Accounts that do not
+// exist are missing from the result.
+func (rw *ReadWriterPG) ReadBalancesAt(ctx context.Context, accountIDs []uuid.UUID, at time.Time) (map[uuid.UUID]pelucio.Balance, error) {
+	return rw.balancesAt(ctx, accountIDs, at, true)
+}
+
+type balanceRow struct {
+	AccountID uuid.UUID      `db:"account_id"`
+	Currency  sql.NullString `db:"currency"`
+	Amount    NullBigInt     `db:"amount"`
+}
+
+// balancesAt sums the entries of the given accounts per currency, applying the
+// normal side of each account. Only transactions executed up to at are
+// considered, or strictly before it when inclusive is false.
+func (rw *ReadWriterPG) balancesAt(ctx context.Context, accountIDs []uuid.UUID, at time.Time, inclusive bool) (map[uuid.UUID]pelucio.Balance, error) {
+	operator := "<"
+	if inclusive {
+		operator = "<="
+	}
+
+	rows := []*balanceRow{}
+	err := rw.selectContext(ctx, rw.ext(), &rows, `
+		SELECT accounts.id AS account_id,
+			entries.currency,
+			SUM(CASE WHEN entries.entry_side = accounts.normal_side THEN entries.amount ELSE -entries.amount END) AS amount
+		FROM `+rw.table("accounts")+` AS accounts
+		LEFT JOIN (`+rw.table("entries")+` AS entries
+			JOIN `+rw.table("transactions")+` AS transactions
+			ON transactions.id = entries.transaction_id AND transactions.executed_at `+operator+` $2)
+		ON entries.account_id = accounts.id
+		WHERE accounts.id = ANY($1::uuid[])
+		GROUP BY accounts.id, entries.currency
+	`, pq.Array(xuuid.ToStrings(accountIDs...)), at)
+	if err != nil {
+		return nil, err
+	}
+
+	balances := make(map[uuid.UUID]pelucio.Balance, len(accountIDs))
+	for _, row := range rows {
+		balance, ok := balances[row.AccountID]
+		if !ok {
+			balance = make(pelucio.Balance)
+			balances[row.AccountID] = balance
+		}
+
+		if row.Currency.Valid && row.Amount.Valid {
+			balance[pelucio.Currency(row.Currency.String)] = row.Amount.Amount
+		}
+	}
+
+	return balances, nil
+}
+
+// StatementLine is an entry of an account statement, with the transaction it
+// belongs to and the balance of the account in the entry currency right
+// after it.
+type StatementLine struct {
+	pelucio.Entry
+	TransactionExternalID  string    `json:"transaction_external_id"`
+	TransactionDescription string    `json:"transaction_description"`
+	ExecutedAt             time.Time `json:"executed_at"`
+	RunningBalance         *big.Int  `json:"running_balance"`
+}
+
+// AccountStatement lists the entries of an account executed within a period
+// in chronological order. OpeningBalance is the balance before the period and
+// ClosingBalance the balance at its end; both are the same on every page.
+type AccountStatement struct {
+	AccountID       uuid.UUID        `json:"account_id"`
+	From            time.Time        `json:"from"`
+	To              time.Time        `json:"to"`
+	OpeningBalance  pelucio.Balance  `json:"opening_balance"`
+	ClosingBalance  pelucio.Balance  `json:"closing_balance"`
+	Lines           []*StatementLine `json:"lines"`
+	PaginationToken *string          `json:"pagination_token,omitempty"`
+}
+
+type statementLine struct {
+	entry
+	TransactionExternalID  string     `db:"transaction_external_id"`
+	TransactionDescription string     `db:"transaction_description"`
+	ExecutedAt             time.Time  `db:"executed_at"`
+	PeriodBalance          NullBigInt `db:"period_balance"`
+}
+
+// ReadAccountStatement returns the statement of an account for the
+// transactions executed between from and to, both inclusive.
+func (rw *ReadWriterPG) ReadAccountStatement(ctx context.Context,
+	accountID uuid.UUID,
+	from, to time.Time,
+	paginationToken *string,
+	limit *uint) (*AccountStatement, error) {
+	opening, err := rw.balancesAt(ctx, []uuid.UUID{accountID}, from, false)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := opening[accountID]; !ok {
+		return nil, pelucio.ErrNotFound
+	}
+
+	closing, err := rw.balancesAt(ctx, []uuid.UUID{accountID}, to, true)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint := paginationFingerprint("statement", []interface{}{accountID, from, to})
+	args := []interface{}{accountID, from, to}
+	query := `
+		SELECT * FROM (
+			SELECT entries.*,
+				transactions.external_id AS transaction_external_id,
+				transactions.description AS transaction_description,
+				transactions.executed_at,
+				SUM(CASE WHEN entries.entry_side = accounts.normal_side THEN entries.amount ELSE -entries.amount END)
+					OVER (PARTITION BY entries.currency ORDER BY transactions.executed_at, entries.id ROWS UNBOUNDED PRECEDING) AS period_balance
+			FROM ` + rw.table("entries") + ` AS entries
+			JOIN ` + rw.table("transactions") + ` AS transactions ON transactions.id = entries.transaction_id
+			JOIN ` + rw.table("accounts") + ` AS accounts ON accounts.id = entries.account_id
+			WHERE entries.account_id = ? AND transactions.executed_at >= ? AND transactions.executed_at <= ?
+		) AS lines `
+	if paginationToken != nil {
+		cursor, err := rw.decodePaginationToken(*paginationToken, fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		query += " WHERE (executed_at, id) > (?, ?)"
+		args = append(args, cursor.Key, cursor.ID)
+	}
+	query += " ORDER BY executed_at ASC, id ASC"
+	if limit != nil {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	query = rw.DB.Rebind(query)
+
+	rows := []*statementLine{}
+	err = rw.selectContext(ctx, rw.ext(), &rows, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := &AccountStatement{
+		AccountID:      accountID,
+		From:           from,
+		To:             to,
+		OpeningBalance: opening[accountID],
+		ClosingBalance: closing[accountID],
+		Lines:          make([]*StatementLine, len(rows)),
+	}
+	for i, row := range rows {
+		runningBalance := new(big.Int).Set(statement.OpeningBalance.Get(row.Currency))
+		if row.PeriodBalance.Valid {
+			runningBalance.Add(runningBalance, row.PeriodBalance.Amount)
+		}
+
+		statement.Lines[i] = &StatementLine{
+			Entry:                  *row.ToEntry(),
+			TransactionExternalID:  row.TransactionExternalID,
+			TransactionDescription: row.TransactionDescription,
+			ExecutedAt:             row.ExecutedAt,
+			RunningBalance:         runningBalance,
+		}
+	}
+
+	if len(rows) > 0 && limit != nil {
+		last := rows[len(rows)-1]
+		s := rw.generatePaginationToken(pageCursor{
+			Direction: pageNext,
+			SortKey:   SortByExecutedAt,
+			Order:     Ascending,
+			Key:       last.ExecutedAt,
+			ID:        last.ID,
+		}, fingerprint)
+		statement.PaginationToken = &s
+	}
+
+	return statement, nil
+}
@@ -0,0 +1,123 @@
+package peluciopg
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/devmalloni/pelucio"
+	"github.com/devmalloni/pelucio/x/xuuid"
+	"github.com/gofrs/uuid/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadBalanceAt(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	accountID := xuuid.New()
+	at := time.Now()
+
+	mock.ExpectQuery("SELECT (.+) FROM accounts AS accounts LEFT JOIN (.+) transactions.executed_at <= \\$2(.+) GROUP BY accounts.id, entries.currency").
+		WithArgs(sqlmock.AnyArg(), at).
+		WillReturnRows(sqlmock.NewRows([]string{"account_id", "currency", "amount"}).
+			AddRow(accountID, "BRL", []byte("150")).
+			AddRow(accountID, "USD", []byte("-20")))
+
+	balance, err := db.ReadBalanceAt(context.Background(), accountID, at)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(150), balance.Get("BRL").Int64())
+	assert.Equal(t, int64(-20), balance.Get("USD").Int64())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReadBalanceAt_NoEntries(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	accountID := xuuid.New()
+
+	mock.ExpectQuery("SELECT (.+) FROM accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"account_id", "currency", "amount"}).
+			AddRow(accountID, nil, nil))
+
+	balance, err := db.ReadBalanceAt(context.Background(), accountID, time.Now())
+	assert.NoError(t, err)
+	assert.Empty(t, balance)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReadBalanceAt_NotFound(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	mock.ExpectQuery("SELECT (.+) FROM accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"account_id", "currency", "amount"}))
+
+	_, err := db.ReadBalanceAt(context.Background(), xuuid.New(), time.Now())
+	assert.ErrorIs(t, err, pelucio.ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReadBalancesAt(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	firstID := xuuid.New()
+	secondID := xuuid.New()
+
+	mock.ExpectQuery("SELECT (.+) FROM accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"account_id", "currency", "amount"}).
+			AddRow(firstID, "BRL", []byte("10")).
+			AddRow(secondID, "BRL", []byte("10")))
+
+	balances, err := db.ReadBalancesAt(context.Background(), []uuid.UUID{firstID, secondID}, time.Now())
+	assert.NoError(t, err)
+	assert.Len(t, balances, 2)
+	assert.Equal(t, int64(10), balances[secondID].Get("BRL").Int64())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReadAccountStatement(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	accountID := xuuid.New()
+	from := time.Now().Add(-24 * time.Hour)
+	to := time.Now()
+	limit := uint(2)
+
+	firstEntry := &pelucio.Entry{ID: xuuid.New(), TransactionID: xuuid.New(), AccountID: accountID, EntrySide: pelucio.Debit, AccountSide: pelucio.Debit, Amount: big.NewInt(30), Currency: "BRL", CreatedAt: from}
+	secondEntry := &pelucio.Entry{ID: xuuid.New(), TransactionID: xuuid.New(), AccountID: accountID, EntrySide: pelucio.Credit, AccountSide: pelucio.Debit, Amount: big.NewInt(10), Currency: "BRL", CreatedAt: to}
+
+	mock.ExpectQuery("SELECT (.+) transactions.executed_at < \\$2").
+		WithArgs(sqlmock.AnyArg(), from).
+		WillReturnRows(sqlmock.NewRows([]string{"account_id", "currency", "amount"}).AddRow(accountID, "BRL", []byte("100")))
+	mock.ExpectQuery("SELECT (.+) transactions.executed_at <= \\$2").
+		WithArgs(sqlmock.AnyArg(), to).
+		WillReturnRows(sqlmock.NewRows([]string{"account_id", "currency", "amount"}).AddRow(accountID, "BRL", []byte("120")))
+
+	columns := []string{"id", "transaction_id", "account_id", "entry_side", "account_side", "amount", "currency", "created_at",
+		"transaction_external_id", "transaction_description", "executed_at", "period_balance"}
+	mock.ExpectQuery("SELECT \\* FROM \\( SELECT (.+) OVER \\(PARTITION BY entries.currency (.+) \\) AS lines ORDER BY executed_at ASC, id ASC LIMIT \\$4").
+		WithArgs(accountID, from, to, &limit).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(firstEntry.ID, firstEntry.TransactionID, accountID, firstEntry.EntrySide, firstEntry.AccountSide, []byte("30"), "BRL", firstEntry.CreatedAt, "deposit-1", "deposit", from, []byte("30")).
+			AddRow(secondEntry.ID, secondEntry.TransactionID, accountID, secondEntry.EntrySide, secondEntry.AccountSide, []byte("10"), "BRL", secondEntry.CreatedAt, "withdraw-1", "withdraw", to, []byte("20")))
+
+	statement, err := db.ReadAccountStatement(context.Background(), accountID, from, to, nil, &limit)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), statement.OpeningBalance.Get("BRL").Int64())
+	assert.Equal(t, int64(120), statement.ClosingBalance.Get("BRL").Int64())
+	assert.Len(t, statement.Lines, 2)
+	assert.Equal(t, "deposit-1", statement.Lines[0].TransactionExternalID)
+	assert.Equal(t, int64(130), statement.Lines[0].RunningBalance.Int64())
+	assert.Equal(t, int64(120), statement.Lines[1].RunningBalance.Int64())
+	cursor, err := db.decodePaginationToken(*statement.PaginationToken, paginationFingerprint("statement", []interface{}{accountID, from, to}))
+	assert.NoError(t, err)
+	assert.Equal(t, secondEntry.ID, cursor.ID)
+	assert.True(t, to.Equal(cursor.Key))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
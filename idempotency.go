@@ -0,0 +1,93 @@
+package peluciopg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/devmalloni/pelucio"
+)
+
+var (
+	ErrIdempotencyConflict = errors.New("transaction external id already used with different entries")
+)
+
+// WriteTransactionIdempotent writes transaction unless a transaction with the
+// same external ID already exists. When it does and its entries match the
+// given ones, nothing is written and the stored transaction is returned, so a
+// request can be safely replayed after a timeout. When the entries differ,
+// ErrIdempotencyConflict is returned.
+func (rw *ReadWriterPG) WriteTransactionIdempotent(ctx context.Context, transaction *pelucio.Transaction, accounts ...*pelucio.Account) (*pelucio.Transaction, error) {
+	var stored *pelucio.Transaction
+	err := rw.writeWithRetry(ctx, transaction, accounts, func(accounts []*pelucio.Account) error {
+		return rw.RunInTx(ctx, func(rw *ReadWriterPG) error {
+			var err error
+			stored, err = rw.writeTransactionIdempotent(ctx, transaction, accounts...)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stored, nil
+}
+
+func (rw *ReadWriterPG) writeTransactionIdempotent(ctx context.Context, transaction *pelucio.Transaction, accounts ...*pelucio.Account) (*pelucio.Transaction, error) {
+	if err := rw.lockTransactionAccounts(ctx, transaction, accounts); err != nil {
+		return nil, err
+	}
+
+	dbTransaction := newTransactionFromPelucio(transaction)
+	res, err := rw.namedExecContext(ctx, rw.ext(), `
+		INSERT INTO `+rw.table("transactions")+` (id, external_id, description, metadata, created_at, executed_at)
+		VALUES (:id, :external_id, :description, :metadata, :created_at, :executed_at)
+		ON CONFLICT (external_id) DO NOTHING
+	`, dbTransaction)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 1 {
+		return transaction, rw.applyTransaction(ctx, dbTransaction, accounts...)
+	}
+
+	stored, err := rw.ReadTransactionByExternalID(ctx, transaction.ExternalID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !sameEntries(stored.Entries, transaction.Entries) {
+		return nil, fmt.Errorf("%w: %s", ErrIdempotencyConflict, transaction.ExternalID)
+	}
+
+	return stored, nil
+}
+
+// sameEntries reports whether both sets of entries move the same amounts on
+// the same accounts, regardless of entry IDs and order.
+func sameEntries(a, b []*pelucio.Entry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	key := func(e *pelucio.Entry) string {
+		return fmt.Sprintf("%s|%s|%s|%s|%s", e.AccountID, e.EntrySide, e.AccountSide, e.Currency, e.Amount)
+	}
+
+	keysA := make([]string, len(a))
+	keysB := make([]string, len(b))
+	for i := range a {
+		keysA[i] = key(a[i])
+		keysB[i] = key(b[i])
+	}
+	slices.Sort(keysA)
+	slices.Sort(keysB)
+
+	return slices.Equal(keysA, keysB)
+}
@@ -0,0 +1,101 @@
+package peluciopg
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/devmalloni/pelucio"
+	"github.com/devmalloni/pelucio/x/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func entryRows(entries ...*pelucio.Entry) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{
+		"id",
+		"transaction_id",
+		"account_id",
+		"entry_side",
+		"account_side",
+		"amount",
+		"currency",
+		"created_at"})
+	for _, e := range entries {
+		rows.AddRow(e.ID, e.TransactionID, e.AccountID, e.EntrySide, e.AccountSide, e.Amount.String(), e.Currency, e.CreatedAt)
+	}
+
+	return rows
+}
+
+func TestWriteTransactionIdempotent_FirstWrite(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	firstAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Debit))
+	secondAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Credit))
+	transaction := pelucio.Deposit("external", firstAccount.ID, secondAccount.ID, big.NewInt(100), "USD")
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO transactions .* ON CONFLICT \\(external_id\\) DO NOTHING").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO entries").WillReturnResult(sqlmock.NewResult(1, 2))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO account_balances").WillReturnResult(sqlmock.NewResult(1, 2))
+	mock.ExpectCommit()
+
+	stored, err := db.WriteTransactionIdempotent(context.Background(), transaction, firstAccount, secondAccount)
+	assert.NoError(t, err)
+	assert.Equal(t, transaction.ID, stored.ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWriteTransactionIdempotent_Replay(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	WithIdempotentWrites()(db)
+
+	firstAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Debit))
+	secondAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Credit))
+	original := pelucio.Deposit("external", firstAccount.ID, secondAccount.ID, big.NewInt(100), "USD")
+	replay := pelucio.Deposit("external", firstAccount.ID, secondAccount.ID, big.NewInt(100), "USD")
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO transactions .* ON CONFLICT \\(external_id\\) DO NOTHING").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT (.+) FROM transactions WHERE external_id = \\$1").
+		WithArgs(original.ExternalID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "external_id", "description", "metadata", "created_at"}).
+			AddRow(original.ID, original.ExternalID, original.Description, []byte("{}"), original.CreatedAt))
+	mock.ExpectQuery("SELECT (.+) FROM entries WHERE transaction_id = \\$1").
+		WithArgs(original.ID).
+		WillReturnRows(entryRows(original.Entries...))
+	mock.ExpectCommit()
+
+	err := db.WriteTransaction(context.Background(), replay, firstAccount, secondAccount)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWriteTransactionIdempotent_Conflict(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	firstAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Debit))
+	secondAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Credit))
+	original := pelucio.Deposit("external", firstAccount.ID, secondAccount.ID, big.NewInt(100), "USD")
+	replay := pelucio.Deposit("external", firstAccount.ID, secondAccount.ID, big.NewInt(200), "USD")
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO transactions .* ON CONFLICT \\(external_id\\) DO NOTHING").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT (.+) FROM transactions WHERE external_id = \\$1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "external_id", "description", "metadata", "created_at"}).
+			AddRow(original.ID, original.ExternalID, original.Description, []byte("{}"), original.CreatedAt))
+	mock.ExpectQuery("SELECT (.+) FROM entries WHERE transaction_id = \\$1").
+		WillReturnRows(entryRows(original.Entries...))
+	mock.ExpectRollback()
+
+	stored, err := db.WriteTransactionIdempotent(context.Background(), replay, firstAccount, secondAccount)
+	assert.ErrorIs(t, err, ErrIdempotencyConflict)
+	assert.Nil(t, stored)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,342 @@
+package peluciopg
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"iter"
+	"strings"
+	"time"
+
+	"github.com/devmalloni/pelucio"
+	"github.com/gofrs/uuid/v5"
+	"github.com/lib/pq"
+)
+
+var ErrInvalidImport = errors.New("invalid import")
+
+// DefaultImportProgressEvery is how many rows are copied between two progress
+// reports unless ImportOptions says otherwise.
+const DefaultImportProgressEvery = 10000
+
+// ImportSource yields the records Import loads. Entries are read apart from
+// the transactions, whose Entries are ignored, so each table is streamed in
+// one go; the balances of accounts are ignored too, they are recomputed from
+// the entries.
+type ImportSource interface {
+	Accounts() iter.Seq2[*pelucio.Account, error]
+	Transactions() iter.Seq2[*pelucio.Transaction, error]
+	Entries() iter.Seq2[*pelucio.Entry, error]
+}
+
+type ImportStage string
+
+const (
+	ImportCopying    ImportStage = "copying"
+	ImportValidating ImportStage = "validating"
+	ImportSwapping   ImportStage = "swapping"
+	ImportDone       ImportStage = "done"
+)
+
+// ImportProgress is how far an import went, with the number of rows copied
+// from the source so far.
+type ImportProgress struct {
+	Stage        ImportStage
+	Accounts     int64
+	Transactions int64
+	Entries      int64
+}
+
+type ImportOptions struct {
+	// Progress, when set, is called while rows are copied and as the import
+	// moves to each stage.
+	Progress func(ImportProgress)
+	// ProgressEvery is how many rows are copied between two calls of
+	// Progress. Defaults to DefaultImportProgressEvery.
+	ProgressEvery int64
+}
+
+var (
+	importAccountColumns     = []string{"id", "external_id", "name", "metadata", "normal_side", "version", "created_at", "updated_at", "deleted_at"}
+	importTransactionColumns = []string{"id", "external_id", "description", "metadata", "created_at", "executed_at"}
+	importEntryColumns       = []string{"id", "transaction_id", "account_id", "entry_side", "account_side", "amount", "currency", "created_at"}
+
+	// importTables are the staging tables of an import and the tables they
+	// are moved to, in the order foreign keys require.
+	importTables = []struct {
+		staging, table string
+		columns        []string
+	}{
+		{"peluciopg_import_accounts", "accounts", importAccountColumns},
+		{"peluciopg_import_transactions", "transactions", importTransactionColumns},
+		{"peluciopg_import_entries", "entries", importEntryColumns},
+	}
+)
+
+// Import loads the accounts, transactions and entries of source in one
+// database transaction. Rows are streamed with COPY FROM STDIN into staging
+// tables, checked to balance and to reference transactions and accounts
+// that exist, then moved to the ledger, whose balances are recomputed from
+// the entries of the accounts moved. Either everything is imported or
+// nothing is; invalid imports fail with ErrInvalidImport or an
+// UnbalancedTransactionError.
+//
+// Imported transactions record no ledger events nor notifications, and run
+// without the statement timeout.
+func (rw *ReadWriterPG) Import(ctx context.Context, source ImportSource, opts ImportOptions) (*ImportProgress, error) {
+	progress := &ImportProgress{Stage: ImportCopying}
+	every := opts.ProgressEvery
+	if every <= 0 {
+		every = DefaultImportProgressEvery
+	}
+	report := func(stage ImportStage) {
+		progress.Stage = stage
+		if opts.Progress != nil {
+			opts.Progress(*progress)
+		}
+	}
+	copied := func(counter *int64) func() {
+		return func() {
+			*counter++
+			if (progress.Accounts+progress.Transactions+progress.Entries)%every == 0 {
+				report(ImportCopying)
+			}
+		}
+	}
+
+	imp := *rw
+	imp.statementTimeout = 0
+	err := imp.RunInTx(ctx, func(rw *ReadWriterPG) error {
+		if err := rw.createImportTables(ctx); err != nil {
+			return err
+		}
+
+		report(ImportCopying)
+		version := rw.now().UnixNano()
+		err := rw.copyIn(ctx, "peluciopg_import_accounts", importAccountColumns,
+			copyValues(source.Accounts(), func(account *pelucio.Account) []interface{} {
+				return []interface{}{account.ID, account.ExternalID, account.Name, jsonValue(account.Metadata),
+					string(account.NormalSide), version, account.CreatedAt, account.UpdatedAt, account.DeletedAt}
+			}), copied(&progress.Accounts))
+		if err != nil {
+			return err
+		}
+		err = rw.copyIn(ctx, "peluciopg_import_transactions", importTransactionColumns,
+			copyValues(source.Transactions(), func(transaction *pelucio.Transaction) []interface{} {
+				return []interface{}{transaction.ID, transaction.ExternalID, transaction.Description,
+					jsonValue(transaction.Metadata), transaction.CreatedAt, transaction.ExecutedAt}
+			}), copied(&progress.Transactions))
+		if err != nil {
+			return err
+		}
+		err = rw.copyIn(ctx, "peluciopg_import_entries", importEntryColumns,
+			copyValues(source.Entries(), func(entry *pelucio.Entry) []interface{} {
+				var amount interface{}
+				if entry.Amount != nil {
+					amount = entry.Amount.String()
+				}
+				return []interface{}{entry.ID, entry.TransactionID, entry.AccountID, string(entry.EntrySide),
+					string(entry.AccountSide), amount, string(entry.Currency), entry.CreatedAt}
+			}), copied(&progress.Entries))
+		if err != nil {
+			return err
+		}
+
+		report(ImportValidating)
+		if err := rw.validateImport(ctx); err != nil {
+			return err
+		}
+
+		report(ImportSwapping)
+		return rw.swapImport(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report(ImportDone)
+	return progress, nil
+}
+
+// createImportTables creates the staging tables, dropped when the
+// transaction ends. A caller's transaction may run several imports, which
+// find the tables of the previous one and empty them.
+func (rw *ReadWriterPG) createImportTables(ctx context.Context) error {
+	staging := make([]string, len(importTables))
+	for i, t := range importTables {
+		_, err := rw.execContext(ctx, rw.ext(),
+			"CREATE TEMPORARY TABLE IF NOT EXISTS "+t.staging+" (LIKE "+rw.table(t.table)+" INCLUDING DEFAULTS) ON COMMIT DROP")
+		if err != nil {
+			return err
+		}
+		staging[i] = t.staging
+	}
+
+	_, err := rw.execContext(ctx, rw.ext(), "TRUNCATE "+strings.Join(staging, ", "))
+	return err
+}
+
+// copyIn streams rows into table with COPY FROM STDIN, calling copied after
+// each of them.
+func (rw *ReadWriterPG) copyIn(ctx context.Context, table string, columns []string, rows iter.Seq2[[]interface{}, error], copied func()) (err error) {
+	query := pq.CopyIn(table, columns...)
+	startedAt := time.Now()
+	defer func() {
+		rw.logStatement(ctx, query, startedAt, err)
+		err = translateError(err)
+	}()
+
+	stmt, err := rw.tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for values, err := range rows {
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.ExecContext(ctx, values...); err != nil {
+			return err
+		}
+		copied()
+	}
+
+	// flushes the rows buffered by the driver
+	_, err = stmt.ExecContext(ctx)
+	return err
+}
+
+// validateImport checks that the staged transactions balance and that the
+// staged entries reference staged transactions and existing accounts.
+func (rw *ReadWriterPG) validateImport(ctx context.Context) error {
+	var unbalanced struct {
+		TransactionID uuid.UUID        `db:"transaction_id"`
+		Currency      pelucio.Currency `db:"currency"`
+	}
+	err := rw.getContext(ctx, rw.ext(), &unbalanced, `
+		SELECT transaction_id, currency
+		FROM peluciopg_import_entries
+		GROUP BY transaction_id, currency
+		HAVING SUM(CASE WHEN entry_side = 'debit' THEN amount ELSE 0 END)
+			<> SUM(CASE WHEN entry_side = 'credit' THEN amount ELSE 0 END)
+		LIMIT 1
+	`)
+	if err == nil {
+		return &UnbalancedTransactionError{
+			TransactionID: unbalanced.TransactionID,
+			Message:       fmt.Sprintf("transaction %s is not balanced in currency %s", unbalanced.TransactionID, unbalanced.Currency),
+		}
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	var dangling struct {
+		ID  uuid.UUID `db:"id"`
+		Ref uuid.UUID `db:"ref"`
+	}
+	err = rw.getContext(ctx, rw.ext(), &dangling, `
+		SELECT entries.id, entries.transaction_id AS ref
+		FROM peluciopg_import_entries AS entries
+		WHERE NOT EXISTS (SELECT 1 FROM peluciopg_import_transactions AS transactions WHERE transactions.id = entries.transaction_id)
+		LIMIT 1
+	`)
+	if err == nil {
+		return fmt.Errorf("%w: entry %s references unknown transaction %s", ErrInvalidImport, dangling.ID, dangling.Ref)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	err = rw.getContext(ctx, rw.ext(), &dangling, `
+		SELECT entries.id, entries.account_id AS ref
+		FROM peluciopg_import_entries AS entries
+		WHERE NOT EXISTS (SELECT 1 FROM peluciopg_import_accounts AS accounts WHERE accounts.id = entries.account_id)
+			AND NOT EXISTS (SELECT 1 FROM `+rw.table("accounts")+` AS accounts WHERE accounts.id = entries.account_id)
+		LIMIT 1
+	`)
+	if err == nil {
+		return fmt.Errorf("%w: entry %s references unknown account %s", ErrInvalidImport, dangling.ID, dangling.Ref)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	return nil
+}
+
+// swapImport moves the staged rows to the ledger and recomputes the balances
+// of the accounts the staged entries move.
+//
+// validateImport already checked the staged transactions balance, and none
+// of the ledger's can gain entries since the staged transactions are new, so
+// entries are moved with the entries_balanced trigger off: it would
+// otherwise queue an event per row, held in memory until commit.
+func (rw *ReadWriterPG) swapImport(ctx context.Context) error {
+	for _, t := range importTables {
+		if t.table == "entries" {
+			if err := rw.setBulkImport(ctx, "on"); err != nil {
+				return err
+			}
+		}
+
+		columns := strings.Join(t.columns, ", ")
+		_, err := rw.execContext(ctx, rw.ext(),
+			"INSERT INTO "+rw.table(t.table)+" ("+columns+") SELECT "+columns+" FROM "+t.staging)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the setting is transaction scoped, but a caller-owned transaction may
+	// keep writing entries after the import
+	if err := rw.setBulkImport(ctx, ""); err != nil {
+		return err
+	}
+
+	_, err := rw.execContext(ctx, rw.ext(), `
+		INSERT INTO `+rw.table("account_balances")+` AS account_balances (account_id, currency, amount)
+		SELECT entries.account_id,
+			entries.currency,
+			SUM(CASE WHEN entries.entry_side = accounts.normal_side THEN entries.amount ELSE -entries.amount END)
+		FROM `+rw.table("entries")+` AS entries
+		JOIN `+rw.table("accounts")+` AS accounts ON accounts.id = entries.account_id
+		WHERE entries.account_id IN (SELECT account_id FROM peluciopg_import_entries)
+		GROUP BY entries.account_id, entries.currency
+		ON CONFLICT (account_id, currency) DO UPDATE SET
+			amount = EXCLUDED.amount
+	`)
+
+	return err
+}
+
+func (rw *ReadWriterPG) setBulkImport(ctx context.Context, value string) error {
+	var previous string
+	return rw.getContext(ctx, rw.ext(), &previous, "SELECT set_config('peluciopg.bulk_import', $1, true)", value)
+}
+
+// copyValues maps the records of seq to the values of their COPY rows.
+func copyValues[T any](seq iter.Seq2[T, error], values func(T) []interface{}) iter.Seq2[[]interface{}, error] {
+	return func(yield func([]interface{}, error) bool) {
+		for record, err := range seq {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(values(record), nil) {
+				return
+			}
+		}
+	}
+}
+
+// jsonValue is raw as COPY expects a jsonb column, which it would otherwise
+// encode as bytea.
+func jsonValue(raw []byte) interface{} {
+	if raw == nil {
+		return nil
+	}
+	return string(raw)
+}
@@ -0,0 +1,145 @@
+package peluciopg
+
+import (
+	"context"
+	"iter"
+	"math/big"
+	"slices"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/devmalloni/pelucio"
+	"github.com/devmalloni/pelucio/x/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+type sliceImportSource struct {
+	accounts     []*pelucio.Account
+	transactions []*pelucio.Transaction
+}
+
+func (s *sliceImportSource) Accounts() iter.Seq2[*pelucio.Account, error] {
+	return withNilErrors(slices.Values(s.accounts))
+}
+
+func (s *sliceImportSource) Transactions() iter.Seq2[*pelucio.Transaction, error] {
+	return withNilErrors(slices.Values(s.transactions))
+}
+
+func (s *sliceImportSource) Entries() iter.Seq2[*pelucio.Entry, error] {
+	entries := []*pelucio.Entry{}
+	for _, transaction := range s.transactions {
+		entries = append(entries, transaction.Entries...)
+	}
+	return withNilErrors(slices.Values(entries))
+}
+
+func withNilErrors[T any](seq iter.Seq[T]) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for v := range seq {
+			if !yield(v, nil) {
+				return
+			}
+		}
+	}
+}
+
+func newImportSource() *sliceImportSource {
+	from := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Debit))
+	to := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Credit))
+
+	return &sliceImportSource{
+		accounts: []*pelucio.Account{from, to},
+		transactions: []*pelucio.Transaction{
+			pelucio.Deposit("external", from.ID, to.ID, big.NewInt(100), "USD"),
+			pelucio.Deposit("external", from.ID, to.ID, big.NewInt(50), "USD"),
+		},
+	}
+}
+
+func expectImportCopy(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	for _, table := range []string{"accounts", "transactions", "entries"} {
+		mock.ExpectExec("CREATE TEMPORARY TABLE IF NOT EXISTS peluciopg_import_" + table + " \\(LIKE " + table + " INCLUDING DEFAULTS\\) ON COMMIT DROP").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	// left over by a previous import in the same transaction
+	mock.ExpectExec("TRUNCATE peluciopg_import_accounts, peluciopg_import_transactions, peluciopg_import_entries").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, staged := range []struct {
+		table string
+		rows  int
+	}{{"accounts", 2}, {"transactions", 2}, {"entries", 4}} {
+		copyIn := mock.ExpectPrepare(`COPY "peluciopg_import_` + staged.table + `" (.+) FROM STDIN`)
+		// one more exec flushes the copy
+		for range staged.rows + 1 {
+			copyIn.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	source := newImportSource()
+	expectImportCopy(mock)
+	mock.ExpectQuery("FROM peluciopg_import_entries GROUP BY transaction_id, currency HAVING").
+		WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "currency"}))
+	mock.ExpectQuery("entries.transaction_id AS ref").WillReturnRows(sqlmock.NewRows([]string{"id", "ref"}))
+	mock.ExpectQuery("entries.account_id AS ref").WillReturnRows(sqlmock.NewRows([]string{"id", "ref"}))
+	mock.ExpectExec("INSERT INTO accounts (.+) SELECT (.+) FROM peluciopg_import_accounts").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO transactions (.+) SELECT (.+) FROM peluciopg_import_transactions").WillReturnResult(sqlmock.NewResult(0, 2))
+	// the staged entries were checked to balance, the trigger is skipped
+	mock.ExpectQuery("SELECT set_config\\('peluciopg.bulk_import', \\$1, true\\)").WithArgs("on").
+		WillReturnRows(sqlmock.NewRows([]string{"set_config"}).AddRow("on"))
+	mock.ExpectExec("INSERT INTO entries (.+) SELECT (.+) FROM peluciopg_import_entries").WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectQuery("SELECT set_config\\('peluciopg.bulk_import', \\$1, true\\)").WithArgs("").
+		WillReturnRows(sqlmock.NewRows([]string{"set_config"}).AddRow(""))
+	mock.ExpectExec("INSERT INTO account_balances (.+) SET amount = EXCLUDED.amount").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	stages := []ImportStage{}
+	progress, err := db.Import(context.Background(), source, ImportOptions{
+		Progress:      func(p ImportProgress) { stages = append(stages, p.Stage) },
+		ProgressEvery: 4,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &ImportProgress{Stage: ImportDone, Accounts: 2, Transactions: 2, Entries: 4}, progress)
+	assert.Equal(t, []ImportStage{ImportCopying, ImportCopying, ImportCopying, ImportValidating, ImportSwapping, ImportDone}, stages)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestImport_Unbalanced(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	source := newImportSource()
+	expectImportCopy(mock)
+	mock.ExpectQuery("FROM peluciopg_import_entries GROUP BY transaction_id, currency HAVING").
+		WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "currency"}).AddRow(source.transactions[1].ID, "USD"))
+	mock.ExpectRollback()
+
+	progress, err := db.Import(context.Background(), source, ImportOptions{})
+	assert.Nil(t, progress)
+	assert.ErrorIs(t, err, ErrUnbalancedTransaction)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestImport_UnknownAccount(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	source := newImportSource()
+	expectImportCopy(mock)
+	mock.ExpectQuery("GROUP BY transaction_id, currency HAVING").WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "currency"}))
+	mock.ExpectQuery("entries.transaction_id AS ref").WillReturnRows(sqlmock.NewRows([]string{"id", "ref"}))
+	mock.ExpectQuery("entries.account_id AS ref").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ref"}).AddRow(source.transactions[0].Entries[0].ID, source.accounts[0].ID))
+	mock.ExpectRollback()
+
+	_, err := db.Import(context.Background(), source, ImportOptions{})
+	assert.ErrorIs(t, err, ErrInvalidImport)
+	assert.ErrorContains(t, err, source.accounts[0].ID.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
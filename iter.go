@@ -0,0 +1,83 @@
+package peluciopg
+
+import (
+	"context"
+	"iter"
+
+	"github.com/devmalloni/pelucio"
+)
+
+// DefaultIterPageSize is how many rows the iterators fetch per query when the
+// filter has no Limit.
+const DefaultIterPageSize uint = 500
+
+// IterAccounts walks every account matching filter, fetching them a page of
+// filter.Limit rows at a time so memory stays bounded. It stops at the first
+// error, which is yielded, including the cancellation of ctx.
+func (rw *ReadWriterPG) IterAccounts(ctx context.Context, filter pelucio.ReadAccountFilter, opts PageOptions) iter.Seq2[*pelucio.Account, error] {
+	filter.Limit = iterPageSize(filter.Limit)
+	return iterPages(ctx, filter.PaginationToken, func(token *string) (*Page[*pelucio.Account], error) {
+		filter.PaginationToken = token
+		return rw.ReadAccountsPage(ctx, filter, opts)
+	})
+}
+
+// IterTransactions is IterAccounts for transactions, entries included.
+func (rw *ReadWriterPG) IterTransactions(ctx context.Context, filter pelucio.ReadTransactionFilter, opts PageOptions) iter.Seq2[*pelucio.Transaction, error] {
+	filter.Limit = iterPageSize(filter.Limit)
+	return iterPages(ctx, filter.PaginationToken, func(token *string) (*Page[*pelucio.Transaction], error) {
+		filter.PaginationToken = token
+		return rw.ReadTransactionsPage(ctx, filter, opts)
+	})
+}
+
+// IterEntries is IterAccounts for entries.
+func (rw *ReadWriterPG) IterEntries(ctx context.Context, filter pelucio.ReadEntryFilter, opts PageOptions) iter.Seq2[*pelucio.Entry, error] {
+	filter.Limit = iterPageSize(filter.Limit)
+	return iterPages(ctx, filter.PaginationToken, func(token *string) (*Page[*pelucio.Entry], error) {
+		filter.PaginationToken = token
+		return rw.ReadEntriesPage(ctx, filter, opts)
+	})
+}
+
+func iterPageSize(limit *uint) *uint {
+	if limit != nil && *limit > 0 {
+		return limit
+	}
+
+	size := DefaultIterPageSize
+	return &size
+}
+
+// iterPages yields the items of the pages read from start on, following
+// NextToken until the last page.
+func iterPages[T any](ctx context.Context, start *string, read func(token *string) (*Page[T], error)) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		// each range starts over from start
+		token := start
+		for {
+			if err := ctx.Err(); err != nil {
+				yield(zero, err)
+				return
+			}
+
+			page, err := read(token)
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+
+			for _, item := range page.Items {
+				if !yield(item, nil) {
+					return
+				}
+			}
+
+			if page.NextToken == nil {
+				return
+			}
+			token = page.NextToken
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package peluciopg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/devmalloni/pelucio"
+	"github.com/devmalloni/pelucio/x/xuuid"
+	"github.com/gofrs/uuid/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIterEntries(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	limit := uint(2)
+	now := time.Now()
+	ids := []uuid.UUID{xuuid.New(), xuuid.New(), xuuid.New()}
+	columns := []string{"id", "transaction_id", "account_id", "entry_side", "account_side", "amount", "currency", "created_at"}
+
+	mock.ExpectQuery("SELECT \\* FROM entries AS entries ORDER BY entries.created_at DESC, entries.id DESC LIMIT \\$1").
+		WithArgs(limit + 1).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(ids[0], xuuid.New(), xuuid.New(), pelucio.Debit, pelucio.Debit, "1", "BRL", now).
+			AddRow(ids[1], xuuid.New(), xuuid.New(), pelucio.Debit, pelucio.Debit, "1", "BRL", now).
+			AddRow(ids[2], xuuid.New(), xuuid.New(), pelucio.Debit, pelucio.Debit, "1", "BRL", now))
+	mock.ExpectQuery("WHERE \\(entries.created_at, entries.id\\) < \\(\\$1, \\$2\\)").
+		WithArgs(timeArg(now), ids[1], limit+1).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(ids[2], xuuid.New(), xuuid.New(), pelucio.Debit, pelucio.Debit, "1", "BRL", now))
+
+	seen := []uuid.UUID{}
+	for e, err := range db.IterEntries(context.Background(), pelucio.ReadEntryFilter{Limit: &limit}, PageOptions{}) {
+		assert.NoError(t, err)
+		seen = append(seen, e.ID)
+	}
+	assert.Equal(t, ids, seen)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIterEntries_StopsOnCancel(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	limit := uint(1)
+	now := time.Now()
+	columns := []string{"id", "transaction_id", "account_id", "entry_side", "account_side", "amount", "currency", "created_at"}
+	mock.ExpectQuery("SELECT \\* FROM entries").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(xuuid.New(), xuuid.New(), xuuid.New(), pelucio.Debit, pelucio.Debit, "1", "BRL", now).
+			AddRow(xuuid.New(), xuuid.New(), xuuid.New(), pelucio.Debit, pelucio.Debit, "1", "BRL", now))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var errs []error
+	for _, err := range db.IterEntries(ctx, pelucio.ReadEntryFilter{Limit: &limit}, PageOptions{}) {
+		errs = append(errs, err)
+		cancel()
+	}
+	assert.Len(t, errs, 2)
+	assert.NoError(t, errs[0])
+	assert.ErrorIs(t, errs[1], context.Canceled)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIterEntries_RangedTwice(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	limit := uint(1)
+	now := time.Now()
+	ids := []uuid.UUID{xuuid.New(), xuuid.New()}
+	columns := []string{"id", "transaction_id", "account_id", "entry_side", "account_side", "amount", "currency", "created_at"}
+
+	// both ranges start over from the first page
+	for range 2 {
+		mock.ExpectQuery("SELECT \\* FROM entries AS entries ORDER BY entries.created_at DESC, entries.id DESC LIMIT \\$1").
+			WithArgs(limit + 1).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(ids[0], xuuid.New(), xuuid.New(), pelucio.Debit, pelucio.Debit, "1", "BRL", now).
+				AddRow(ids[1], xuuid.New(), xuuid.New(), pelucio.Debit, pelucio.Debit, "1", "BRL", now))
+		mock.ExpectQuery("WHERE \\(entries.created_at, entries.id\\) < \\(\\$1, \\$2\\)").
+			WithArgs(timeArg(now), ids[0], limit+1).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(ids[1], xuuid.New(), xuuid.New(), pelucio.Debit, pelucio.Debit, "1", "BRL", now))
+	}
+
+	entries := db.IterEntries(context.Background(), pelucio.ReadEntryFilter{Limit: &limit}, PageOptions{})
+	for range 2 {
+		seen := []uuid.UUID{}
+		for e, err := range entries {
+			assert.NoError(t, err)
+			seen = append(seen, e.ID)
+		}
+		assert.Equal(t, ids, seen)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
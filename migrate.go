@@ -1,13 +1,15 @@
 package peluciopg
 
 import (
+	"context"
 	"embed"
 
 	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 //go:embed migrations/*.sql
@@ -18,10 +20,11 @@ func NilInt(i int) *int {
 }
 
 func (p *ReadWriterPG) Migrate(forceVersion *int, databaseName string, config *postgres.Config) error {
-	driver, err := postgres.WithInstance(p.DB.DB, config)
+	driver, release, err := p.migrationDriver(config)
 	if err != nil {
 		return err
 	}
+	defer release()
 
 	d, err := iofs.New(migrationsFolder, "migrations")
 	if err != nil {
@@ -51,3 +54,52 @@ func (p *ReadWriterPG) Migrate(forceVersion *int, databaseName string, config *p
 
 	return err
 }
+
+// migrationDriver returns a migration driver bound to the configured schema.
+// When a schema is set, migrations run on a dedicated connection whose
+// search_path points to it, so the tables are created where ReadWriterPG
+// expects them.
+func (p *ReadWriterPG) migrationDriver(config *postgres.Config) (database.Driver, func(), error) {
+	if config == nil {
+		return nil, nil, postgres.ErrNilConfig
+	}
+	if p.schema == "" {
+		driver, err := postgres.WithInstance(p.DB.DB, config)
+		return driver, func() {}, err
+	}
+
+	ctx := context.Background()
+	conn, err := p.DB.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the connection goes back to the shared pool, so search_path is restored
+	release := func() {
+		_, _ = conn.ExecContext(ctx, "RESET search_path")
+		conn.Close()
+	}
+
+	_, err = conn.ExecContext(ctx, "CREATE SCHEMA IF NOT EXISTS "+pq.QuoteIdentifier(p.schema))
+	if err == nil {
+		_, err = conn.ExecContext(ctx, "SET search_path TO "+pq.QuoteIdentifier(p.schema))
+	}
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	// the caller's config is left as given
+	schemaConfig := *config
+	if schemaConfig.SchemaName == "" {
+		schemaConfig.SchemaName = p.schema
+	}
+
+	driver, err := postgres.WithConnection(ctx, conn, &schemaConfig)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	return driver, release, nil
+}
@@ -0,0 +1,18 @@
+package peluciopg
+
+import (
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrate_NilConfig(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	WithSchema("ledger")(db)
+
+	err := db.Migrate(nil, "peluciopg", nil)
+	assert.ErrorIs(t, err, postgres.ErrNilConfig)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,11 @@
+BEGIN;
+
+CREATE TABLE account_balances (
+    "account_id" uuid NOT NULL,
+    "currency" varchar(32) NOT NULL,
+    "amount" numeric(78,0) NOT NULL DEFAULT 0,
+    PRIMARY KEY ("account_id", "currency"),
+    CONSTRAINT account_balances_accounts FOREIGN KEY (account_id) REFERENCES accounts (id)
+);
+
+END;
@@ -0,0 +1,13 @@
+BEGIN;
+
+ALTER TABLE accounts ADD COLUMN balance jsonb;
+
+UPDATE accounts SET balance = COALESCE((
+    SELECT jsonb_object_agg(account_balances.currency, account_balances.amount)
+    FROM account_balances
+    WHERE account_balances.account_id = accounts.id
+), '{}'::jsonb);
+
+DELETE FROM account_balances;
+
+END;
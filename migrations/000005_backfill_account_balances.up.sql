@@ -0,0 +1,10 @@
+BEGIN;
+
+INSERT INTO account_balances (account_id, currency, amount)
+SELECT accounts.id, balance.key, (balance.value #>> '{}')::numeric(78,0)
+FROM accounts, jsonb_each(accounts.balance) AS balance
+WHERE jsonb_typeof(accounts.balance) = 'object';
+
+ALTER TABLE accounts DROP COLUMN balance;
+
+END;
@@ -0,0 +1,7 @@
+BEGIN;
+
+ALTER TABLE entries DROP CONSTRAINT entries_amount_check;
+
+ALTER TABLE entries ALTER COLUMN amount TYPE text USING amount::text;
+
+END;
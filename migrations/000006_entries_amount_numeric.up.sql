@@ -0,0 +1,15 @@
+BEGIN;
+
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM entries WHERE amount !~ '^[0-9]+$') THEN
+        RAISE EXCEPTION 'entries.amount holds values that are not non-negative integers';
+    END IF;
+END
+$$;
+
+ALTER TABLE entries ALTER COLUMN amount TYPE numeric(78,0) USING amount::numeric(78,0);
+
+ALTER TABLE entries ADD CONSTRAINT entries_amount_check CHECK (amount >= 0);
+
+END;
@@ -0,0 +1,9 @@
+BEGIN;
+
+DROP TRIGGER entries_balanced ON entries;
+
+DROP FUNCTION entries_check_balanced();
+
+DROP FUNCTION check_transaction_balanced(uuid);
+
+END;
@@ -0,0 +1,42 @@
+BEGIN;
+
+CREATE FUNCTION check_transaction_balanced(checked_transaction_id uuid) RETURNS void AS $$
+DECLARE
+    unbalanced_currency varchar(32);
+BEGIN
+    SELECT currency INTO unbalanced_currency
+    FROM entries
+    WHERE transaction_id = checked_transaction_id
+    GROUP BY currency
+    HAVING SUM(CASE WHEN entry_side = 'debit' THEN amount ELSE 0 END)
+        <> SUM(CASE WHEN entry_side = 'credit' THEN amount ELSE 0 END)
+    LIMIT 1;
+
+    IF FOUND THEN
+        RAISE EXCEPTION 'transaction % is not balanced in currency %', checked_transaction_id, unbalanced_currency
+            USING ERRCODE = 'PL001',
+                  CONSTRAINT = 'entries_balanced',
+                  DETAIL = checked_transaction_id::text;
+    END IF;
+END;
+$$ LANGUAGE plpgsql SET search_path FROM CURRENT;
+
+CREATE FUNCTION entries_check_balanced() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        PERFORM check_transaction_balanced(OLD.transaction_id);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM check_transaction_balanced(NEW.transaction_id);
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql SET search_path FROM CURRENT;
+
+CREATE CONSTRAINT TRIGGER entries_balanced
+    AFTER INSERT OR UPDATE OR DELETE ON entries
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE PROCEDURE entries_check_balanced();
+
+END;
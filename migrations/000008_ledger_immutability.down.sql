@@ -0,0 +1,20 @@
+BEGIN;
+
+ALTER TABLE entries
+    DROP CONSTRAINT entries_transactions,
+    DROP CONSTRAINT entries_accounts,
+    ADD CONSTRAINT entries_transactions FOREIGN KEY (transaction_id) REFERENCES transactions (id) ON DELETE CASCADE,
+    ADD CONSTRAINT entries_accounts FOREIGN KEY (account_id) REFERENCES accounts (id) ON DELETE CASCADE;
+
+DROP TRIGGER transactions_no_truncate ON transactions;
+DROP TRIGGER entries_no_truncate ON entries;
+DROP TRIGGER transactions_immutable ON transactions;
+DROP TRIGGER entries_immutable ON entries;
+
+DROP FUNCTION ledger_reject_truncate();
+DROP FUNCTION ledger_guard_mutation();
+
+DROP TABLE ledger_mutations;
+DROP TABLE ledger_corrections;
+
+END;
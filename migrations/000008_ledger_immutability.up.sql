@@ -0,0 +1,81 @@
+BEGIN;
+
+CREATE TABLE ledger_corrections (
+    "id" uuid NOT NULL,
+    PRIMARY KEY ("id"),
+    "performed_by" varchar(255) NOT NULL,
+    "reason" text NOT NULL,
+    "created_at" timestamp NOT NULL
+);
+
+CREATE TABLE ledger_mutations (
+    "id" bigserial NOT NULL,
+    PRIMARY KEY ("id"),
+    "correction_id" uuid NOT NULL,
+    "table_name" varchar(64) NOT NULL,
+    "operation" varchar(10) NOT NULL,
+    "old_row" jsonb NOT NULL,
+    "new_row" jsonb,
+    "created_at" timestamp NOT NULL,
+    CONSTRAINT ledger_mutations_corrections FOREIGN KEY (correction_id) REFERENCES ledger_corrections (id)
+);
+
+-- Rows of entries and transactions can only be changed inside a ledger
+-- correction, which sets peluciopg.correction_id for its transaction. Every
+-- change is then recorded in ledger_mutations.
+CREATE FUNCTION ledger_guard_mutation() RETURNS trigger AS $$
+DECLARE
+    correction_id text := current_setting('peluciopg.correction_id', true);
+BEGIN
+    IF correction_id IS NULL OR correction_id = '' THEN
+        RAISE EXCEPTION '% on % is not allowed, ledger rows are append-only', TG_OP, TG_TABLE_NAME
+            USING ERRCODE = 'PL002';
+    END IF;
+
+    INSERT INTO ledger_mutations (correction_id, table_name, operation, old_row, new_row, created_at)
+    VALUES (
+        correction_id::uuid,
+        TG_TABLE_NAME,
+        TG_OP,
+        row_to_json(OLD)::jsonb,
+        CASE WHEN TG_OP = 'UPDATE' THEN row_to_json(NEW)::jsonb END,
+        now()
+    );
+
+    IF TG_OP = 'DELETE' THEN
+        RETURN OLD;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql SET search_path FROM CURRENT;
+
+CREATE FUNCTION ledger_reject_truncate() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'TRUNCATE on % is not allowed, ledger rows are append-only', TG_TABLE_NAME
+        USING ERRCODE = 'PL002';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER entries_immutable
+    BEFORE UPDATE OR DELETE ON entries
+    FOR EACH ROW EXECUTE PROCEDURE ledger_guard_mutation();
+
+CREATE TRIGGER transactions_immutable
+    BEFORE UPDATE OR DELETE ON transactions
+    FOR EACH ROW EXECUTE PROCEDURE ledger_guard_mutation();
+
+CREATE TRIGGER entries_no_truncate
+    BEFORE TRUNCATE ON entries
+    FOR EACH STATEMENT EXECUTE PROCEDURE ledger_reject_truncate();
+
+CREATE TRIGGER transactions_no_truncate
+    BEFORE TRUNCATE ON transactions
+    FOR EACH STATEMENT EXECUTE PROCEDURE ledger_reject_truncate();
+
+ALTER TABLE entries
+    DROP CONSTRAINT entries_transactions,
+    DROP CONSTRAINT entries_accounts,
+    ADD CONSTRAINT entries_transactions FOREIGN KEY (transaction_id) REFERENCES transactions (id) ON DELETE RESTRICT,
+    ADD CONSTRAINT entries_accounts FOREIGN KEY (account_id) REFERENCES accounts (id) ON DELETE RESTRICT;
+
+END;
@@ -0,0 +1,5 @@
+BEGIN;
+
+DROP TABLE balance_repairs;
+
+END;
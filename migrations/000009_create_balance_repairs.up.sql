@@ -0,0 +1,18 @@
+BEGIN;
+
+CREATE TABLE balance_repairs (
+    "id" uuid NOT NULL,
+    PRIMARY KEY ("id"),
+    "account_id" uuid NOT NULL,
+    "currency" varchar(32) NOT NULL,
+    "stored_amount" numeric(78,0) NOT NULL,
+    "computed_amount" numeric(78,0) NOT NULL,
+    "performed_by" varchar(255) NOT NULL,
+    "reason" text NOT NULL,
+    "created_at" timestamp NOT NULL,
+    CONSTRAINT balance_repairs_accounts FOREIGN KEY (account_id) REFERENCES accounts (id)
+);
+
+CREATE INDEX balance_repairs_account_id_idx ON balance_repairs (account_id, created_at);
+
+END;
@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP INDEX idx_entries_transactionid_createdat_id;
+
+DROP INDEX idx_entries_accountid_createdat_id;
+
+END;
@@ -0,0 +1,7 @@
+BEGIN;
+
+CREATE INDEX idx_entries_accountid_createdat_id ON entries (account_id, created_at, id);
+
+CREATE INDEX idx_entries_transactionid_createdat_id ON entries (transaction_id, created_at, id);
+
+END;
@@ -0,0 +1,5 @@
+BEGIN;
+
+DROP INDEX idx_transactions_executedat_id;
+
+END;
@@ -0,0 +1,5 @@
+BEGIN;
+
+CREATE INDEX idx_transactions_executedat_id ON transactions (executed_at, id);
+
+END;
@@ -0,0 +1,22 @@
+BEGIN;
+
+CREATE TABLE ledger_events (
+    "id" bigserial NOT NULL,
+    PRIMARY KEY ("id"),
+    "event_type" varchar(64) NOT NULL,
+    "transaction_id" uuid NOT NULL,
+    "account_id" uuid,
+    "payload" jsonb NOT NULL,
+    "status" varchar(16) NOT NULL DEFAULT 'pending',
+    "attempts" integer NOT NULL DEFAULT 0,
+    "last_error" text,
+    "available_at" timestamp NOT NULL,
+    "created_at" timestamp NOT NULL,
+    "delivered_at" timestamp,
+    CONSTRAINT ledger_events_status_check CHECK (status IN ('pending', 'delivered', 'dead'))
+);
+
+-- the relay only scans pending events
+CREATE INDEX ledger_events_pending_idx ON ledger_events (available_at, id) WHERE status = 'pending';
+
+END;
@@ -0,0 +1,10 @@
+BEGIN;
+
+DROP TRIGGER entries_balanced ON entries;
+
+CREATE CONSTRAINT TRIGGER entries_balanced
+    AFTER INSERT OR UPDATE OR DELETE ON entries
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE PROCEDURE entries_check_balanced();
+
+END;
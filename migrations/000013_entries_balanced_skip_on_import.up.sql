@@ -0,0 +1,15 @@
+BEGIN;
+
+-- Imports check their staged entries balance once, before moving them all
+-- with one statement. They set peluciopg.bulk_import for that statement, so
+-- no event is queued per imported row.
+DROP TRIGGER entries_balanced ON entries;
+
+CREATE CONSTRAINT TRIGGER entries_balanced
+    AFTER INSERT OR UPDATE OR DELETE ON entries
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW
+    WHEN (current_setting('peluciopg.bulk_import', true) IS DISTINCT FROM 'on')
+    EXECUTE PROCEDURE entries_check_balanced();
+
+END;
@@ -0,0 +1,171 @@
+package peluciopg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/devmalloni/pelucio"
+	"github.com/gofrs/uuid/v5"
+	"github.com/lib/pq"
+)
+
+const (
+	// DefaultNotifyChannel is the channel WithNotifications uses when given
+	// an empty one.
+	DefaultNotifyChannel = "peluciopg_ledger"
+
+	// EventNotificationsGap is sent to subscribers after the listener
+	// connection was lost and re-established: notifications sent meanwhile
+	// are lost, so state derived from them must be reloaded.
+	EventNotificationsGap LedgerEventType = "notifications.gap"
+
+	// maxNotifyPayload stays below the 8000 bytes Postgres accepts.
+	maxNotifyPayload = 7900
+)
+
+var ErrListenerUnavailable = errors.New("subscribing requires notifications and a listener dsn")
+
+// SubscribeFilter restricts a subscription to transactions moving any of
+// AccountIDs, in any of Currencies. Empty fields match everything.
+type SubscribeFilter struct {
+	AccountIDs []uuid.UUID
+	Currencies []pelucio.Currency
+}
+
+func (f SubscribeFilter) match(posted *TransactionPosted) bool {
+	if len(f.AccountIDs) == 0 && len(f.Currencies) == 0 {
+		return true
+	}
+	// a truncated notification can't be told apart, let the subscriber look
+	if posted.Truncated {
+		return true
+	}
+
+	for _, change := range posted.Changes {
+		if (len(f.AccountIDs) == 0 || slices.Contains(f.AccountIDs, change.AccountID)) &&
+			(len(f.Currencies) == 0 || slices.Contains(f.Currencies, change.Currency)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notifyTransaction notifies the configured channel that transaction was
+// posted. Postgres delivers the notification when the transaction commits.
+func (rw *ReadWriterPG) notifyTransaction(ctx context.Context, transaction *pelucio.Transaction, deltas []*balanceDelta) error {
+	if rw.notifyChannel == "" {
+		return nil
+	}
+
+	payload, err := notificationPayload(transaction, deltas)
+	if err != nil {
+		return err
+	}
+
+	var discard string
+	return rw.getContext(ctx, rw.ext(), &discard, "SELECT pg_notify($1, $2)", rw.notifyChannel, payload)
+}
+
+// notificationPayload is the TransactionPosted notified for transaction,
+// without its changes when they don't fit in a notification.
+func notificationPayload(transaction *pelucio.Transaction, deltas []*balanceDelta) (string, error) {
+	posted := newTransactionPosted(transaction, deltas)
+	payload, err := json.Marshal(posted)
+	if err != nil {
+		return "", err
+	}
+	if len(payload) > maxNotifyPayload {
+		posted.Changes, posted.Truncated = nil, true
+		if payload, err = json.Marshal(posted); err != nil {
+			return "", err
+		}
+	}
+
+	return string(payload), nil
+}
+
+// Subscribe listens to the notifications of posted transactions on a
+// dedicated connection, reconnecting when it is lost. Events have the
+// EventTransactionPosted type with a TransactionPosted payload, or the
+// EventNotificationsGap type after a reconnection. The channel is closed
+// once ctx is done.
+func (rw *ReadWriterPG) Subscribe(ctx context.Context, filter SubscribeFilter) (<-chan LedgerEvent, error) {
+	if rw.notifyChannel == "" || rw.listenerDSN == "" {
+		return nil, ErrListenerUnavailable
+	}
+
+	listener := pq.NewListener(rw.listenerDSN, 100*time.Millisecond, 30*time.Second, func(event pq.ListenerEventType, err error) {
+		if err != nil && rw.logger != nil {
+			rw.logger.WarnContext(ctx, "peluciopg: ledger listener", "event", event, "error", err.Error())
+		}
+	})
+
+	listening := make(chan error, 1)
+	go func() { listening <- listener.Listen(rw.notifyChannel) }()
+	select {
+	case err := <-listening:
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+	case <-ctx.Done():
+		listener.Close()
+		return nil, ctx.Err()
+	}
+
+	events := make(chan LedgerEvent)
+	go func() {
+		defer close(events)
+		defer listener.Close()
+
+		for {
+			var event *LedgerEvent
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Minute):
+				// notices a dead connection even when nothing is posted
+				go listener.Ping()
+				continue
+			case n := <-listener.Notify:
+				event = notificationEvent(n, filter, rw.now())
+			}
+			if event == nil {
+				continue
+			}
+
+			select {
+			case events <- *event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// notificationEvent turns a notification into the event sent to subscribers,
+// or nil when it does not match filter. A nil notification is how the
+// listener signals it reconnected. Events are stamped with now.
+func notificationEvent(n *pq.Notification, filter SubscribeFilter, now time.Time) *LedgerEvent {
+	if n == nil {
+		return &LedgerEvent{Type: EventNotificationsGap, CreatedAt: now}
+	}
+
+	var posted TransactionPosted
+	if err := json.Unmarshal([]byte(n.Extra), &posted); err != nil || !filter.match(&posted) {
+		return nil
+	}
+
+	return &LedgerEvent{
+		Type:          EventTransactionPosted,
+		TransactionID: posted.TransactionID,
+		Payload:       json.RawMessage(n.Extra),
+		CreatedAt:     now,
+	}
+}
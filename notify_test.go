@@ -0,0 +1,95 @@
+package peluciopg
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/devmalloni/pelucio"
+	"github.com/devmalloni/pelucio/x/xtime"
+	"github.com/devmalloni/pelucio/x/xuuid"
+	"github.com/gofrs/uuid/v5"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteTransaction_WithNotifications(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	WithNotifications("")(db)
+
+	firstAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Debit))
+	secondAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Credit))
+	transaction := pelucio.Deposit("external", firstAccount.ID, secondAccount.ID, big.NewInt(100), "USD")
+
+	var posted TransactionPosted
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO entries").WillReturnResult(sqlmock.NewResult(1, 2))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO account_balances").WillReturnResult(sqlmock.NewResult(1, 2))
+	mock.ExpectQuery("SELECT pg_notify\\(\\$1, \\$2\\)").
+		WithArgs(DefaultNotifyChannel, stringArg(func(s string) bool { return json.Unmarshal([]byte(s), &posted) == nil })).
+		WillReturnRows(sqlmock.NewRows([]string{"pg_notify"}).AddRow(""))
+	mock.ExpectCommit()
+
+	err := db.WriteTransaction(context.Background(), transaction, firstAccount, secondAccount)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, transaction.ID, posted.TransactionID)
+	assert.Len(t, posted.Changes, 2)
+}
+
+func TestNotificationEvent(t *testing.T) {
+	accountID := xuuid.New()
+	posted := &TransactionPosted{
+		TransactionID: xuuid.New(),
+		Changes: []*BalanceChange{
+			{AccountID: accountID, Currency: "BRL", Amount: big.NewInt(10)},
+		},
+	}
+	n := &pq.Notification{Extra: string(mustJSON(t, posted))}
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	event := notificationEvent(n, SubscribeFilter{AccountIDs: []uuid.UUID{accountID}, Currencies: []pelucio.Currency{"BRL"}}, now)
+	assert.NotNil(t, event)
+	assert.Equal(t, EventTransactionPosted, event.Type)
+	assert.Equal(t, posted.TransactionID, event.TransactionID)
+	assert.Equal(t, now, event.CreatedAt)
+
+	assert.Nil(t, notificationEvent(n, SubscribeFilter{AccountIDs: []uuid.UUID{xuuid.New()}}, now))
+	assert.Nil(t, notificationEvent(n, SubscribeFilter{Currencies: []pelucio.Currency{"USD"}}, now))
+
+	// a reconnection is reported as a gap
+	gap := notificationEvent(nil, SubscribeFilter{}, now)
+	assert.Equal(t, EventNotificationsGap, gap.Type)
+	assert.Equal(t, now, gap.CreatedAt)
+}
+
+func TestSubscribe_RequiresListener(t *testing.T) {
+	db, _, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	_, err := db.Subscribe(context.Background(), SubscribeFilter{})
+	assert.ErrorIs(t, err, ErrListenerUnavailable)
+}
+
+type stringArg func(s string) bool
+
+func (p stringArg) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	return ok && p(s)
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,132 @@
+package peluciopg
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"time"
+
+	"github.com/devmalloni/pelucio"
+	"github.com/gofrs/uuid/v5"
+)
+
+type LedgerEventType string
+
+const (
+	// EventTransactionPosted is recorded once per transaction, with a
+	// TransactionPosted payload.
+	EventTransactionPosted LedgerEventType = "transaction.posted"
+	// EventBalanceChanged is recorded once per account and currency a
+	// transaction moves, with a BalanceChange payload.
+	EventBalanceChanged LedgerEventType = "balance.changed"
+)
+
+// LedgerEvent is a row of the ledger_events outbox.
+type LedgerEvent struct {
+	ID            int64           `json:"id" db:"id"`
+	Type          LedgerEventType `json:"type" db:"event_type"`
+	TransactionID uuid.UUID       `json:"transaction_id" db:"transaction_id"`
+	AccountID     *uuid.UUID      `json:"account_id,omitempty" db:"account_id"`
+	Payload       json.RawMessage `json:"payload" db:"payload"`
+	Attempts      int             `json:"attempts" db:"attempts"`
+	CreatedAt     time.Time       `json:"created_at" db:"created_at"`
+}
+
+// BalanceChange is how much a transaction moved the balance of an account in
+// a currency, signed on the account normal side.
+type BalanceChange struct {
+	AccountID uuid.UUID        `json:"account_id"`
+	Currency  pelucio.Currency `json:"currency"`
+	Amount    *big.Int         `json:"amount"`
+}
+
+type TransactionPosted struct {
+	TransactionID uuid.UUID        `json:"transaction_id"`
+	ExternalID    string           `json:"external_id"`
+	ExecutedAt    *time.Time       `json:"executed_at,omitempty"`
+	Changes       []*BalanceChange `json:"changes"`
+	// Truncated is set on notifications too large to carry every change,
+	// which then carry none.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+func newTransactionPosted(transaction *pelucio.Transaction, deltas []*balanceDelta) *TransactionPosted {
+	posted := &TransactionPosted{
+		TransactionID: transaction.ID,
+		ExternalID:    transaction.ExternalID,
+		ExecutedAt:    transaction.ExecutedAt,
+		Changes:       make([]*BalanceChange, len(deltas)),
+	}
+	for i, delta := range deltas {
+		posted.Changes[i] = &BalanceChange{
+			AccountID: delta.AccountID,
+			Currency:  delta.Currency,
+			Amount:    delta.Amount.Amount,
+		}
+	}
+
+	return posted
+}
+
+type ledgerEventRow struct {
+	Type          LedgerEventType `db:"event_type"`
+	TransactionID uuid.UUID       `db:"transaction_id"`
+	AccountID     *uuid.UUID      `db:"account_id"`
+	Payload       NullRawMessage  `db:"payload"`
+	CreatedAt     time.Time       `db:"created_at"`
+}
+
+// writeLedgerEvents records the events of a transaction when the outbox is
+// enabled. It runs in the transaction writing the entries, so events exist
+// if and only if the transaction is posted.
+func (rw *ReadWriterPG) writeLedgerEvents(ctx context.Context, transaction *pelucio.Transaction, deltas []*balanceDelta) error {
+	if !rw.outbox {
+		return nil
+	}
+
+	rows, err := newLedgerEventRows(transaction, deltas, rw.now())
+	if err != nil {
+		return err
+	}
+
+	return rw.insertLedgerEvents(ctx, rows)
+}
+
+func newLedgerEventRows(transaction *pelucio.Transaction, deltas []*balanceDelta, now time.Time) ([]*ledgerEventRow, error) {
+	posted := newTransactionPosted(transaction, deltas)
+	rows := make([]*ledgerEventRow, 0, len(posted.Changes)+1)
+
+	payload, err := json.Marshal(posted)
+	if err != nil {
+		return nil, err
+	}
+	rows = append(rows, &ledgerEventRow{
+		Type:          EventTransactionPosted,
+		TransactionID: transaction.ID,
+		Payload:       NullRawMessage{RawMessage: payload, Valid: true},
+		CreatedAt:     now,
+	})
+
+	for _, change := range posted.Changes {
+		payload, err := json.Marshal(change)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, &ledgerEventRow{
+			Type:          EventBalanceChanged,
+			TransactionID: transaction.ID,
+			AccountID:     &change.AccountID,
+			Payload:       NullRawMessage{RawMessage: payload, Valid: true},
+			CreatedAt:     now,
+		})
+	}
+
+	return rows, nil
+}
+
+func (rw *ReadWriterPG) insertLedgerEvents(ctx context.Context, rows []*ledgerEventRow) error {
+	return insertChunks(ctx, rw, `
+		INSERT INTO `+rw.table("ledger_events")+` (event_type, transaction_id, account_id, payload, available_at, created_at)
+		VALUES (:event_type, :transaction_id, :account_id, :payload, :created_at, :created_at)
+	`, rows)
+}
@@ -0,0 +1,127 @@
+package peluciopg
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/devmalloni/pelucio"
+	"github.com/devmalloni/pelucio/x/xtime"
+	"github.com/devmalloni/pelucio/x/xuuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteTransaction_WithOutbox(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	WithOutbox()(db)
+
+	firstAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Debit))
+	secondAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Credit))
+	transaction := pelucio.Deposit("external", firstAccount.ID, secondAccount.ID, big.NewInt(100), "USD")
+
+	var posted TransactionPosted
+	payload := sqlmock.Argument(jsonArg(func(b []byte) bool {
+		return json.Unmarshal(b, &posted) == nil
+	}))
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO entries").WillReturnResult(sqlmock.NewResult(1, 2))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO account_balances").WillReturnResult(sqlmock.NewResult(1, 2))
+	mock.ExpectExec("INSERT INTO ledger_events").
+		WithArgs(
+			EventTransactionPosted, transaction.ID, nil, payload, sqlmock.AnyArg(), sqlmock.AnyArg(),
+			EventBalanceChanged, transaction.ID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			EventBalanceChanged, transaction.ID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 3))
+	mock.ExpectCommit()
+
+	err := db.WriteTransaction(context.Background(), transaction, firstAccount, secondAccount)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, transaction.ID, posted.TransactionID)
+	assert.Len(t, posted.Changes, 2)
+}
+
+type jsonArg func(b []byte) bool
+
+func (p jsonArg) Match(v driver.Value) bool {
+	b, ok := v.([]byte)
+	return ok && p(b)
+}
+
+type publisherFunc func(ctx context.Context, event *LedgerEvent) error
+
+func (p publisherFunc) Publish(ctx context.Context, event *LedgerEvent) error {
+	return p(ctx, event)
+}
+
+func TestEventRelay_RelayOnce(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	columns := []string{"id", "event_type", "transaction_id", "account_id", "payload", "attempts", "created_at"}
+	published := []int64{}
+	relay := NewEventRelay(db, publisherFunc(func(ctx context.Context, event *LedgerEvent) error {
+		published = append(published, event.ID)
+		if event.ID == 2 {
+			return errors.New("broker down")
+		}
+		return nil
+	}), WithRelayBatchSize(10), WithRelayRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second}))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM ledger_events WHERE status = 'pending' AND available_at <= \\$1 ORDER BY id LIMIT \\$2 FOR UPDATE SKIP LOCKED").
+		WithArgs(sqlmock.AnyArg(), 10).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(1, EventTransactionPosted, xuuid.New(), nil, []byte("{}"), 0, time.Now()).
+			AddRow(2, EventBalanceChanged, xuuid.New(), xuuid.New(), []byte("{}"), 0, time.Now()).
+			AddRow(3, EventBalanceChanged, xuuid.New(), xuuid.New(), []byte("{}"), 2, time.Now()))
+	// delivered, retried later, delivered on its last attempt
+	mock.ExpectExec("UPDATE ledger_events SET status").
+		WithArgs("delivered", 1, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE ledger_events SET status").
+		WithArgs("pending", 1, "broker down", sqlmock.AnyArg(), nil, int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE ledger_events SET status").
+		WithArgs("delivered", 3, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	n, err := relay.RelayOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []int64{1, 2, 3}, published)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestEventRelay_DeadLetters(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	relay := NewEventRelay(db, publisherFunc(func(ctx context.Context, event *LedgerEvent) error {
+		return errors.New("rejected")
+	}), WithRelayRetryPolicy(RetryPolicy{MaxAttempts: 3}))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM ledger_events").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event_type", "transaction_id", "account_id", "payload", "attempts", "created_at"}).
+			AddRow(7, EventTransactionPosted, xuuid.New(), nil, []byte("{}"), 2, time.Now()))
+	mock.ExpectExec("UPDATE ledger_events SET status").
+		WithArgs("dead", 3, "rejected", sqlmock.AnyArg(), nil, int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	_, err := relay.RelayOnce(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,336 @@
+package peluciopg
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/devmalloni/pelucio"
+	"github.com/gofrs/uuid/v5"
+)
+
+type (
+	SortKey   string
+	SortOrder string
+)
+
+const (
+	SortByCreatedAt  SortKey = "created_at"
+	SortByExecutedAt SortKey = "executed_at"
+
+	Descending SortOrder = "desc"
+	Ascending  SortOrder = "asc"
+)
+
+// DefaultPaginationTokenTTL is for how long pagination tokens are accepted
+// unless WithPaginationTokenTTL says otherwise.
+const DefaultPaginationTokenTTL = 24 * time.Hour
+
+var (
+	ErrInvalidPageOptions     = errors.New("invalid sort key or order")
+	ErrInvalidPaginationToken = errors.New("invalid pagination token")
+)
+
+// defaultPaginationKey signs pagination tokens when no key is configured, so
+// they are only valid within the process that issued them.
+var defaultPaginationKey = func() []byte {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+// PageOptions picks the order of a list, newest first by created_at when
+// empty. A pagination token carries the order it was issued for, which
+// takes precedence.
+type PageOptions struct {
+	SortKey SortKey
+	Order   SortOrder
+}
+
+// Page is a slice of a list. NextToken and PrevToken resume the list after
+// the last item or before the first one, and are nil when there is nothing
+// there or the list was not limited.
+type Page[T any] struct {
+	Items     []T     `json:"items"`
+	NextToken *string `json:"next_token,omitempty"`
+	PrevToken *string `json:"prev_token,omitempty"`
+
+	// tail is the token the list methods of pelucio.ReadWriter return: it
+	// follows the last item even at the end of the list, where it yields an
+	// empty page.
+	tail *string
+}
+
+type pageDirection string
+
+const (
+	pageNext pageDirection = "next"
+	pagePrev pageDirection = "prev"
+)
+
+// pageCursor is the position a pagination token points at: the sort key
+// value and id of a row, and on which side of it the requested page is.
+type pageCursor struct {
+	Direction pageDirection
+	SortKey   SortKey
+	Order     SortOrder
+	Key       time.Time
+	ID        uuid.UUID
+}
+
+// keyset holds how a page of a list is fetched.
+type keyset struct {
+	rw          *ReadWriterPG
+	table       string
+	fingerprint []byte
+	sortKey     SortKey
+	order       SortOrder
+	direction   pageDirection
+	cursor      *pageCursor
+	limit       *uint
+}
+
+// newKeyset resolves the page of table to fetch. fingerprint identifies the
+// filter of the list, which tokens are bound to.
+func (rw *ReadWriterPG) newKeyset(table string, fingerprint []byte, sortKeys []SortKey, opts PageOptions, paginationToken *string, limit *uint) (*keyset, error) {
+	k := &keyset{
+		rw:          rw,
+		table:       table,
+		fingerprint: fingerprint,
+		sortKey:     opts.SortKey,
+		order:       opts.Order,
+		direction:   pageNext,
+		limit:       limit,
+	}
+	if paginationToken != nil {
+		cursor, err := rw.decodePaginationToken(*paginationToken, fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		k.sortKey, k.order, k.direction, k.cursor = cursor.SortKey, cursor.Order, cursor.Direction, &cursor
+	}
+	if k.sortKey == "" {
+		k.sortKey = SortByCreatedAt
+	}
+	if k.order == "" {
+		k.order = Descending
+	}
+
+	if !slices.Contains(sortKeys, k.sortKey) || (k.order != Ascending && k.order != Descending) {
+		return nil, ErrInvalidPageOptions
+	}
+
+	return k, nil
+}
+
+// descending tells whether rows are scanned newest first. Previous pages are
+// scanned against the list order and reversed afterwards.
+func (k *keyset) descending() bool {
+	return (k.order == Descending) != (k.direction == pagePrev)
+}
+
+func (k *keyset) column(name string) string {
+	return k.table + "." + name
+}
+
+// condition restricts the rows to the ones past the cursor, if any.
+func (k *keyset) condition() (string, []interface{}, bool) {
+	if k.cursor == nil {
+		return "", nil, false
+	}
+
+	operator := ">"
+	if k.descending() {
+		operator = "<"
+	}
+
+	return fmt.Sprintf("(%s, %s) %s (?, ?)", k.column(string(k.sortKey)), k.column("id"), operator),
+		[]interface{}{k.cursor.Key, k.cursor.ID}, true
+}
+
+// orderBy is the ORDER BY and LIMIT clause. One row more than the limit is
+// fetched to know whether the list goes on.
+func (k *keyset) orderBy() (string, []interface{}) {
+	direction := "ASC"
+	if k.descending() {
+		direction = "DESC"
+	}
+
+	clause := fmt.Sprintf(" ORDER BY %s %s, %s %s", k.column(string(k.sortKey)), direction, k.column("id"), direction)
+	if k.limit == nil {
+		return clause, nil
+	}
+
+	return clause + " LIMIT ?", []interface{}{*k.limit + 1}
+}
+
+// newPage assembles the fetched rows into a Page in list order, key returning
+// the sort key value and id of a row.
+func newPage[T any](k *keyset, rows []T, key func(T) (time.Time, uuid.UUID)) *Page[T] {
+	page := &Page[T]{Items: rows}
+	if k.limit == nil {
+		return page
+	}
+
+	more := len(rows) > int(*k.limit)
+	if more {
+		page.Items = rows[:*k.limit]
+	}
+	if k.direction == pagePrev {
+		slices.Reverse(page.Items)
+	}
+	if len(page.Items) == 0 {
+		return page
+	}
+
+	cursor := func(direction pageDirection, item T) *pageCursor {
+		at, id := key(item)
+		return &pageCursor{Direction: direction, SortKey: k.sortKey, Order: k.order, Key: at, ID: id}
+	}
+	next := k.rw.generatePaginationToken(*cursor(pageNext, page.Items[len(page.Items)-1]), k.fingerprint)
+	page.tail = &next
+
+	hasNext, hasPrev := more, k.cursor != nil
+	if k.direction == pagePrev {
+		hasNext, hasPrev = true, more
+	}
+	if hasNext {
+		page.NextToken = &next
+	}
+	if hasPrev {
+		prev := k.rw.generatePaginationToken(*cursor(pagePrev, page.Items[0]), k.fingerprint)
+		page.PrevToken = &prev
+	}
+
+	return page
+}
+
+// paginationFingerprint identifies a list and the filter it was read with,
+// so a token issued for it is refused by any other.
+func paginationFingerprint(list string, filter interface{}) []byte {
+	b, _ := json.Marshal(filter)
+	sum := sha256.Sum256(append([]byte(list+"|"), b...))
+	return sum[:16]
+}
+
+func accountsFingerprint(filter pelucio.ReadAccountFilter) []byte {
+	filter.PaginationToken, filter.Limit = nil, nil
+	return paginationFingerprint("accounts", filter)
+}
+
+func transactionsFingerprint(filter pelucio.ReadTransactionFilter) []byte {
+	filter.PaginationToken, filter.Limit = nil, nil
+	return paginationFingerprint("transactions", filter)
+}
+
+func entriesFingerprint(filter pelucio.ReadEntryFilter) []byte {
+	filter.PaginationToken, filter.Limit = nil, nil
+	return paginationFingerprint("entries", filter)
+}
+
+const (
+	paginationTokenVersion byte = 1
+
+	// version, expiry in unix nanoseconds and fingerprint
+	paginationTokenHeaderSize = 1 + 8 + 16
+)
+
+// generatePaginationToken signs cursor along with the version of the token
+// format, its expiry and the fingerprint of the list it was issued for.
+func (rw *ReadWriterPG) generatePaginationToken(cursor pageCursor, fingerprint []byte) string {
+	ttl := rw.paginationTokenTTL
+	if ttl == 0 {
+		ttl = DefaultPaginationTokenTTL
+	}
+
+	token := make([]byte, 0, paginationTokenHeaderSize+64+sha256.Size)
+	token = append(token, paginationTokenVersion)
+	token = binary.BigEndian.AppendUint64(token, uint64(rw.now().Add(ttl).UnixNano()))
+	token = append(token, fingerprint...)
+	token = append(token, strings.Join([]string{
+		string(cursor.Direction),
+		string(cursor.SortKey),
+		string(cursor.Order),
+		cursor.Key.Format(time.RFC3339Nano),
+		cursor.ID.String(),
+	}, "|")...)
+	token = rw.signPaginationToken(token)
+
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+// decodePaginationToken returns the cursor of a token generated by
+// generatePaginationToken for the list identified by fingerprint. Tokens that
+// are malformed, tampered with, expired or issued for another list fail with
+// ErrInvalidPaginationToken.
+func (rw *ReadWriterPG) decodePaginationToken(paginationToken string, fingerprint []byte) (cursor pageCursor, err error) {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w: %s", ErrInvalidPaginationToken, reason)
+	}
+
+	token, err := base64.RawURLEncoding.DecodeString(paginationToken)
+	if err != nil || len(token) < paginationTokenHeaderSize+sha256.Size {
+		return cursor, invalid("malformed")
+	}
+	if token[0] != paginationTokenVersion {
+		return cursor, invalid("unsupported version")
+	}
+
+	payload := token[:len(token)-sha256.Size]
+	if !hmac.Equal(rw.signPaginationToken(payload), token) {
+		return cursor, invalid("bad signature")
+	}
+	if rw.now().UnixNano() > int64(binary.BigEndian.Uint64(payload[1:9])) {
+		return cursor, invalid("expired")
+	}
+	if !bytes.Equal(payload[9:paginationTokenHeaderSize], fingerprint) {
+		return cursor, invalid("issued for another filter")
+	}
+
+	splittedToken := strings.Split(string(payload[paginationTokenHeaderSize:]), "|")
+	if len(splittedToken) != 5 {
+		return cursor, invalid("unexpected token length")
+	}
+
+	cursor.Direction = pageDirection(splittedToken[0])
+	if cursor.Direction != pageNext && cursor.Direction != pagePrev {
+		return cursor, invalid("unexpected token direction")
+	}
+	cursor.SortKey = SortKey(splittedToken[1])
+	cursor.Order = SortOrder(splittedToken[2])
+
+	cursor.Key, err = time.Parse(time.RFC3339Nano, splittedToken[3])
+	if err != nil {
+		return cursor, invalid(err.Error())
+	}
+
+	cursor.ID, err = uuid.FromString(splittedToken[4])
+	if err != nil {
+		return cursor, invalid(err.Error())
+	}
+
+	return cursor, nil
+}
+
+// signPaginationToken appends the HMAC-SHA256 of payload to it.
+func (rw *ReadWriterPG) signPaginationToken(payload []byte) []byte {
+	key := rw.paginationKey
+	if len(key) == 0 {
+		key = defaultPaginationKey
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return mac.Sum(payload[:len(payload):len(payload)])
+}
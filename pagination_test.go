@@ -0,0 +1,166 @@
+package peluciopg
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/devmalloni/pelucio"
+	"github.com/devmalloni/pelucio/x/xtime"
+	"github.com/devmalloni/pelucio/x/xuuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaginationToken_RoundTrip(t *testing.T) {
+	rw := &ReadWriterPG{}
+	cursor := pageCursor{
+		Direction: pagePrev,
+		SortKey:   SortByExecutedAt,
+		Order:     Ascending,
+		Key:       time.Now().UTC(),
+		ID:        xuuid.New(),
+	}
+	fingerprint := entriesFingerprint(pelucio.ReadEntryFilter{AccountIDs: []string{"account"}})
+
+	decoded, err := rw.decodePaginationToken(rw.generatePaginationToken(cursor, fingerprint), fingerprint)
+	assert.NoError(t, err)
+	assert.Equal(t, cursor, decoded)
+}
+
+func TestPaginationToken_Invalid(t *testing.T) {
+	now := time.Now()
+	clock := xtime.NewStubClock(now)
+	rw := &ReadWriterPG{clock: clock}
+	WithPaginationKey([]byte("key"))(rw)
+	WithPaginationTokenTTL(time.Minute)(rw)
+
+	cursor := pageCursor{Direction: pageNext, SortKey: SortByCreatedAt, Order: Descending, Key: now, ID: xuuid.New()}
+	filter := pelucio.ReadEntryFilter{AccountIDs: []string{xuuid.New().String()}}
+	token := rw.generatePaginationToken(cursor, entriesFingerprint(filter))
+
+	raw, _ := base64.RawURLEncoding.DecodeString(token)
+	tamper := func(i int) string {
+		tampered := bytes.Clone(raw)
+		tampered[i] ^= 0xff
+		return base64.RawURLEncoding.EncodeToString(tampered)
+	}
+
+	otherKey := &ReadWriterPG{clock: clock}
+	WithPaginationKey([]byte("other"))(otherKey)
+	otherFilter := filter
+	otherFilter.AccountIDs = []string{xuuid.New().String()}
+	expired := &ReadWriterPG{clock: xtime.NewStubClock(now.Add(2 * time.Minute)), paginationKey: rw.paginationKey}
+
+	for name, decode := range map[string]func() error{
+		"malformed": func() error {
+			_, err := rw.decodePaginationToken("not a token", entriesFingerprint(filter))
+			return err
+		},
+		"version": func() error { _, err := rw.decodePaginationToken(tamper(0), entriesFingerprint(filter)); return err },
+		"tampered": func() error {
+			_, err := rw.decodePaginationToken(tamper(len(raw)-40), entriesFingerprint(filter))
+			return err
+		},
+		"other key":    func() error { _, err := otherKey.decodePaginationToken(token, entriesFingerprint(filter)); return err },
+		"expired":      func() error { _, err := expired.decodePaginationToken(token, entriesFingerprint(filter)); return err },
+		"other filter": func() error { _, err := rw.decodePaginationToken(token, entriesFingerprint(otherFilter)); return err },
+		"other list": func() error {
+			_, err := rw.decodePaginationToken(token, transactionsFingerprint(pelucio.ReadTransactionFilter{}))
+			return err
+		},
+	} {
+		assert.ErrorIs(t, decode(), ErrInvalidPaginationToken, name)
+	}
+
+	// page size is not part of the filter a token is bound to
+	limit := uint(10)
+	filter.Limit = &limit
+	_, err := rw.decodePaginationToken(token, entriesFingerprint(filter))
+	assert.NoError(t, err)
+}
+
+func TestReadTransactionsPage_ByExecutedAt(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	limit := uint(1)
+	executedAt := time.Now()
+	first, second := xuuid.New(), xuuid.New()
+	columns := []string{"id", "external_id", "description", "metadata", "created_at", "executed_at"}
+
+	mock.ExpectQuery("FROM transactions AS transactions ORDER BY transactions.executed_at ASC, transactions.id ASC LIMIT \\$1").
+		WithArgs(limit + 1).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(first, "first", "", nil, executedAt, executedAt).
+			AddRow(second, "second", "", nil, executedAt, executedAt))
+
+	mock.ExpectQuery("SELECT \\* FROM entries WHERE transaction_id = ANY").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	page, err := db.ReadTransactionsPage(context.Background(), pelucio.ReadTransactionFilter{Limit: &limit},
+		PageOptions{SortKey: SortByExecutedAt, Order: Ascending})
+	assert.NoError(t, err)
+	assert.Len(t, page.Items, 1)
+	assert.Nil(t, page.PrevToken)
+	assert.NotNil(t, page.NextToken)
+
+	// the token keeps the order it was issued for and goes back from the
+	// first item of the next page
+	mock.ExpectQuery("WHERE \\(transactions.executed_at, transactions.id\\) > \\(\\$1, \\$2\\) ORDER BY transactions.executed_at ASC, transactions.id ASC LIMIT \\$3").
+		WithArgs(timeArg(executedAt), first, limit+1).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(second, "second", "", nil, executedAt, executedAt))
+
+	mock.ExpectQuery("SELECT \\* FROM entries WHERE transaction_id = ANY").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	page, err = db.ReadTransactionsPage(context.Background(), pelucio.ReadTransactionFilter{Limit: &limit, PaginationToken: page.NextToken}, PageOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, page.Items, 1)
+	assert.Equal(t, second, page.Items[0].ID)
+	assert.Nil(t, page.NextToken)
+	assert.NotNil(t, page.PrevToken)
+
+	mock.ExpectQuery("WHERE \\(transactions.executed_at, transactions.id\\) < \\(\\$1, \\$2\\) ORDER BY transactions.executed_at DESC, transactions.id DESC LIMIT \\$3").
+		WithArgs(timeArg(executedAt), second, limit+1).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(first, "first", "", nil, executedAt, executedAt))
+
+	mock.ExpectQuery("SELECT \\* FROM entries WHERE transaction_id = ANY").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	page, err = db.ReadTransactionsPage(context.Background(), pelucio.ReadTransactionFilter{Limit: &limit, PaginationToken: page.PrevToken}, PageOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, first, page.Items[0].ID)
+	assert.Nil(t, page.PrevToken)
+	assert.NotNil(t, page.NextToken)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReadTransactionsPage_EntriesError(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	mock.ExpectQuery("FROM transactions AS transactions").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "external_id", "description", "metadata", "created_at", "executed_at"}).
+			AddRow(xuuid.New(), "first", "", nil, time.Now(), nil))
+	mock.ExpectQuery("SELECT \\* FROM entries WHERE transaction_id = ANY").WillReturnError(sql.ErrConnDone)
+
+	// no page of transactions missing their entries
+	page, err := db.ReadTransactionsPage(context.Background(), pelucio.ReadTransactionFilter{}, PageOptions{})
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+	assert.Nil(t, page)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReadEntriesPage_InvalidSortKey(t *testing.T) {
+	db, _, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	_, err := db.ReadEntriesPage(context.Background(), pelucio.ReadEntryFilter{}, PageOptions{SortKey: SortByExecutedAt})
+	assert.ErrorIs(t, err, ErrInvalidPageOptions)
+}
@@ -4,20 +4,26 @@ import (
 	"context"
 	"database/sql"
 	"database/sql/driver"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"math/big"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/devmalloni/pelucio"
+	"github.com/devmalloni/pelucio/x/xtime"
+	"github.com/devmalloni/pelucio/x/xuuid"
 	"github.com/gofrs/uuid/v5"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
+// NullBigInt represents a *big.Int that may be null. It is stored as numeric
+// and scanned from its text representation.
 type NullBigInt struct {
 	Amount *big.Int
 	Valid  bool
@@ -29,9 +35,16 @@ func (n *NullBigInt) Scan(value interface{}) error {
 		n.Valid = false
 		return nil
 	}
-	str, ok := value.(string)
-	if !ok {
-		return fmt.Errorf("NullRawMessage: failed to unmarshal JSONB value: %v", value)
+	var str string
+	switch v := value.(type) {
+	case []byte:
+		str = string(v)
+	case string:
+		str = v
+	case int64:
+		str = strconv.FormatInt(v, 10)
+	default:
+		return fmt.Errorf("NullBigInt: unsupported value type %T", value)
 	}
 
 	amount, ok := new(big.Int).SetString(str, 10)
@@ -82,6 +95,8 @@ func (n NullRawMessage) Value() (driver.Value, error) {
 
 type account struct {
 	pelucio.Account
+	// Balance is read only: balances are stored in account_balances and
+	// assembled into this column by accountColumns.
 	Balance  NullRawMessage `db:"balance" json:"balance"`
 	Metadata NullRawMessage `db:"metadata" json:"metadata"`
 }
@@ -91,16 +106,6 @@ func newAccountFromPelucio(acc *pelucio.Account) *account {
 		Account: *acc,
 	}
 
-	if acc.Balance != nil {
-		if b, err := json.Marshal(acc.Balance); err == nil {
-			dbAccount.Balance = NullRawMessage{RawMessage: b, Valid: true}
-		} else {
-			panic(err)
-		}
-	} else {
-		dbAccount.Balance = NullRawMessage{Valid: false}
-	}
-
 	if acc.Metadata != nil {
 		dbAccount.Metadata = NullRawMessage{RawMessage: acc.Metadata, Valid: true}
 	} else {
@@ -189,21 +194,202 @@ func newTransactionFromPelucio(tr *pelucio.Transaction) *transaction {
 	return &db
 }
 
+var _ pelucio.ReadWriter = (*ReadWriterPG)(nil)
+
 type ReadWriterPG struct {
 	DB *sqlx.DB
-}
 
-func NewReadWriterPG(ctx context.Context, dsn string) (*ReadWriterPG, error) {
+	// tx is set on ReadWriterPGs returned by WithTx; every statement then
+	// runs inside it and commit/rollback is left to whoever owns it.
+	tx *sqlx.Tx
+
+	schema           string
+	statementTimeout time.Duration
+	logger           *slog.Logger
+	clock            xtime.Clock
+	isolationLevel   sql.IsolationLevel
+	retryPolicy      RetryPolicy
+	idempotentWrites bool
+	concurrencyMode  ConcurrencyMode
+
+	paginationKey      []byte
+	paginationTokenTTL time.Duration
+
+	outbox        bool
+	notifyChannel string
+	listenerDSN   string
+}
+
+// NewReadWriterPG connects to dsn and builds a ReadWriterPG on it.
+//
+// Unless WithPaginationKey is given, pagination tokens are signed with a
+// random key drawn when the process starts: a token issued by one replica, or
+// before a restart, is rejected by the others with ErrInvalidPaginationToken.
+// Any deployment running more than one process, or handing tokens to clients
+// that outlive it, needs a key shared by all of them.
+func NewReadWriterPG(ctx context.Context, dsn string, opts ...ReadWriterPGOpt) (*ReadWriterPG, error) {
 	db, err := sqlx.ConnectContext(ctx, "postgres", dsn)
 	if err != nil {
 		return nil, err
 	}
 
+	return NewReadWriterPGFromSQLX(db, append([]ReadWriterPGOpt{WithListenerDSN(dsn)}, opts...)...), nil
+}
+
+// NewReadWriterPGFromDB builds a ReadWriterPG on top of an existing connection
+// pool, so it can be shared with the rest of the application. As with
+// NewReadWriterPG, pagination tokens only survive the process without
+// WithPaginationKey.
+func NewReadWriterPGFromDB(db *sql.DB, opts ...ReadWriterPGOpt) *ReadWriterPG {
+	return NewReadWriterPGFromSQLX(sqlx.NewDb(db, "postgres"), opts...)
+}
+
+// NewReadWriterPGFromSQLX builds a ReadWriterPG on top of db. As with
+// NewReadWriterPG, pagination tokens only survive the process without
+// WithPaginationKey.
+func NewReadWriterPGFromSQLX(db *sqlx.DB, opts ...ReadWriterPGOpt) *ReadWriterPG {
 	p := &ReadWriterPG{
 		DB: db,
 	}
 
-	return p, nil
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// WithTx returns a ReadWriterPG bound to tx. Writes and reads issued through it
+// share the caller's commit/rollback boundary, so ledger postings can be
+// committed atomically with the application's own rows.
+//
+// Entries are checked to balance when tx commits, outside of any method of
+// ReadWriterPG: pass the error of tx.Commit through TranslateError to get an
+// UnbalancedTransactionError rather than a *pq.Error.
+func (rw *ReadWriterPG) WithTx(tx *sqlx.Tx) *ReadWriterPG {
+	bound := *rw
+	bound.tx = tx
+
+	return &bound
+}
+
+// RunInTx runs fn with a ReadWriterPG bound to a database transaction, committing
+// it when fn succeeds and rolling it back otherwise. When rw is already bound
+// to a transaction, fn joins it.
+func (rw *ReadWriterPG) RunInTx(ctx context.Context, fn func(rw *ReadWriterPG) error) error {
+	if rw.tx != nil {
+		return fn(rw)
+	}
+
+	tx, err := rw.DB.BeginTxx(ctx, &sql.TxOptions{Isolation: rw.isolationLevel})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(rw.WithTx(tx))
+	if err != nil {
+		return err
+	}
+
+	return translateError(tx.Commit())
+}
+
+// ext returns the executor statements must run on.
+func (rw *ReadWriterPG) ext() sqlx.ExtContext {
+	if rw.tx != nil {
+		return rw.tx
+	}
+
+	return rw.DB
+}
+
+func (rw *ReadWriterPG) clockOrDefault() xtime.Clock {
+	if rw.clock == nil {
+		return xtime.DefaultClock
+	}
+
+	return rw.clock
+}
+
+func (rw *ReadWriterPG) now() time.Time {
+	return rw.clockOrDefault().Now()
+}
+
+// table returns the name of a table qualified by the configured schema.
+func (rw *ReadWriterPG) table(name string) string {
+	if rw.schema == "" {
+		return name
+	}
+
+	return pq.QuoteIdentifier(rw.schema) + "." + name
+}
+
+func (rw *ReadWriterPG) statementContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if rw.statementTimeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, rw.statementTimeout)
+}
+
+func (rw *ReadWriterPG) logStatement(ctx context.Context, query string, startedAt time.Time, err error) {
+	if rw.logger == nil {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("query", strings.Join(strings.Fields(query), " ")),
+		slog.Duration("duration", time.Since(startedAt)),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	rw.logger.LogAttrs(ctx, slog.LevelDebug, "peluciopg: statement executed", attrs...)
+}
+
+func (rw *ReadWriterPG) getContext(ctx context.Context, q sqlx.QueryerContext, dest interface{}, query string, args ...interface{}) error {
+	ctx, cancel := rw.statementContext(ctx)
+	defer cancel()
+
+	startedAt := time.Now()
+	err := sqlx.GetContext(ctx, q, dest, query, args...)
+	rw.logStatement(ctx, query, startedAt, err)
+
+	return translateError(err)
+}
+
+func (rw *ReadWriterPG) selectContext(ctx context.Context, q sqlx.QueryerContext, dest interface{}, query string, args ...interface{}) error {
+	ctx, cancel := rw.statementContext(ctx)
+	defer cancel()
+
+	startedAt := time.Now()
+	err := sqlx.SelectContext(ctx, q, dest, query, args...)
+	rw.logStatement(ctx, query, startedAt, err)
+
+	return translateError(err)
+}
+
+func (rw *ReadWriterPG) namedExecContext(ctx context.Context, e sqlx.ExtContext, query string, arg interface{}) (sql.Result, error) {
+	ctx, cancel := rw.statementContext(ctx)
+	defer cancel()
+
+	startedAt := time.Now()
+	res, err := sqlx.NamedExecContext(ctx, e, query, arg)
+	rw.logStatement(ctx, query, startedAt, err)
+
+	return res, translateError(err)
+}
+
+func (rw *ReadWriterPG) execContext(ctx context.Context, e sqlx.ExecerContext, query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := rw.statementContext(ctx)
+	defer cancel()
+
+	startedAt := time.Now()
+	res, err := e.ExecContext(ctx, query, args...)
+	rw.logStatement(ctx, query, startedAt, err)
+
+	return res, translateError(err)
 }
 
 func (rw *ReadWriterPG) WriteAccount(ctx context.Context, account *pelucio.Account, allowUpdate bool) error {
@@ -214,12 +400,16 @@ func (rw *ReadWriterPG) WriteAccount(ctx context.Context, account *pelucio.Accou
 	return rw.insertAccount(ctx, account)
 }
 
+// upsertAccount inserts account or updates it at its version. Balances are
+// only written when the account is inserted: on update they are owned by the
+// transactions posted since.
 func (rw *ReadWriterPG) upsertAccount(ctx context.Context, account *pelucio.Account) error {
 	dbAccount := newAccountFromPelucio(account)
-	dbAccount.Version = time.Now().UnixNano()
-	_, err := rw.DB.NamedExecContext(ctx, `
-			INSERT INTO accounts (id, external_id, name, metadata, normal_side, version, balance, created_at, updated_at, deleted_at)
-			VALUES (:id, :external_id :name, :metadata, :normal_side, :new_version, :balance, :created_at, :updated_at, :deleted_at)
+	dbAccount.Version = rw.now().UnixNano()
+	// xmax is only zero on a row the statement inserted
+	query, args, err := sqlx.Named(`
+			INSERT INTO `+rw.table("accounts")+` AS accounts (id, external_id, name, metadata, normal_side, version, created_at, updated_at, deleted_at)
+			VALUES (:id, :external_id, :name, :metadata, :normal_side, :new_version, :created_at, :updated_at, :deleted_at)
 			ON CONFLICT (id) DO UPDATE SET
 				name       = EXCLUDED.name,
 				metadata   = EXCLUDED.metadata,
@@ -227,7 +417,8 @@ func (rw *ReadWriterPG) upsertAccount(ctx context.Context, account *pelucio.Acco
 				created_at = EXCLUDED.created_at,
 				updated_at = EXCLUDED.updated_at,
 				deleted_at = EXCLUDED.deleted_at
-			WHERE version = :version
+			WHERE accounts.version = :version
+			RETURNING xmax = 0 AS inserted
 		`, map[string]interface{}{
 		"id":          dbAccount.ID,
 		"external_id": dbAccount.ExternalID,
@@ -235,82 +426,125 @@ func (rw *ReadWriterPG) upsertAccount(ctx context.Context, account *pelucio.Acco
 		"metadata":    dbAccount.Metadata,
 		"normal_side": dbAccount.NormalSide,
 		"version":     account.Version,
-		"balance":     dbAccount.Balance,
 		"new_version": dbAccount.Version,
 		"created_at":  dbAccount.CreatedAt,
 		"updated_at":  dbAccount.UpdatedAt,
 		"deleted_at":  dbAccount.DeletedAt,
 	})
+	if err != nil {
+		return err
+	}
+	query = rw.DB.Rebind(query)
+
+	upsert := func(rw *ReadWriterPG) error {
+		var inserted bool
+		err := rw.getContext(ctx, rw.ext(), &inserted, query, args...)
+		if errors.Is(err, sql.ErrNoRows) {
+			return rw.versionConflict(ctx, account.ID, account.Version)
+		}
+		if err != nil || !inserted {
+			return err
+		}
+
+		return rw.incrementBalances(ctx, newBalanceDeltas(account.ID, account.Balance))
+	}
+
+	if !account.Balance.HasBalance() {
+		return upsert(rw)
+	}
 
-	return err
+	return rw.RunInTx(ctx, upsert)
 }
 
 func (rw *ReadWriterPG) insertAccount(ctx context.Context, account *pelucio.Account) error {
 	dbAccount := newAccountFromPelucio(account)
 
-	dbAccount.Version = time.Now().UnixNano()
-	_, err := rw.DB.NamedExecContext(ctx, `
-			INSERT INTO accounts (id, external_id, balance, name, normal_side, metadata, version, created_at)
-			VALUES (:id, :external_id, :balance, :name, :normal_side, :metadata, :version, :created_at)
+	dbAccount.Version = rw.now().UnixNano()
+	insert := func(rw *ReadWriterPG) error {
+		_, err := rw.namedExecContext(ctx, rw.ext(), `
+			INSERT INTO `+rw.table("accounts")+` (id, external_id, name, normal_side, metadata, version, created_at)
+			VALUES (:id, :external_id, :name, :normal_side, :metadata, :version, :created_at)
 		`, dbAccount)
+		if err != nil {
+			return err
+		}
+
+		return rw.incrementBalances(ctx, newBalanceDeltas(account.ID, account.Balance))
+	}
+
+	if !account.Balance.HasBalance() {
+		return insert(rw)
+	}
 
-	return err
+	return rw.RunInTx(ctx, insert)
 }
 
 func (rw *ReadWriterPG) WriteTransaction(ctx context.Context, transaction *pelucio.Transaction, accounts ...*pelucio.Account) error {
-	tx, err := rw.DB.BeginTxx(ctx, nil)
-	if err != nil {
+	if rw.idempotentWrites {
+		_, err := rw.WriteTransactionIdempotent(ctx, transaction, accounts...)
+		return err
+	}
+
+	return rw.writeWithRetry(ctx, transaction, accounts, func(accounts []*pelucio.Account) error {
+		return rw.RunInTx(ctx, func(rw *ReadWriterPG) error {
+			return rw.writeTransaction(ctx, transaction, accounts...)
+		})
+	})
+}
+
+func (rw *ReadWriterPG) writeTransaction(ctx context.Context, transaction *pelucio.Transaction, accounts ...*pelucio.Account) error {
+	if err := rw.lockTransactionAccounts(ctx, transaction, accounts); err != nil {
 		return err
 	}
-	defer tx.Rollback()
 
 	dbTransaction := newTransactionFromPelucio(transaction)
-	_, err = tx.NamedExecContext(ctx, `
-		INSERT INTO transactions (id, external_id, description, metadata, created_at, executed_at)
+	_, err := rw.namedExecContext(ctx, rw.ext(), `
+		INSERT INTO `+rw.table("transactions")+` (id, external_id, description, metadata, created_at, executed_at)
 		VALUES (:id, :external_id, :description, :metadata, :created_at, :executed_at)
 	`, dbTransaction)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.NamedExecContext(ctx, `
-			INSERT INTO entries (id, transaction_id, account_id, entry_side, account_side, amount, currency, created_at)
-			VALUES (:id, :transaction_id, :account_id, :entry_side, :account_side, :amount, :currency, :created_at)		
+	return rw.applyTransaction(ctx, dbTransaction, accounts...)
+}
+
+// applyTransaction writes the entries of an already inserted transaction,
+// bumps the version of the accounts and increments their balances.
+func (rw *ReadWriterPG) applyTransaction(ctx context.Context, dbTransaction *transaction, accounts ...*pelucio.Account) error {
+	if err := rw.checkLockedBalances(ctx, &dbTransaction.Transaction); err != nil {
+		return err
+	}
+
+	_, err := rw.namedExecContext(ctx, rw.ext(), `
+		INSERT INTO `+rw.table("entries")+` (id, transaction_id, account_id, entry_side, account_side, amount, currency, created_at)
+		VALUES (:id, :transaction_id, :account_id, :entry_side, :account_side, :amount, :currency, :created_at)
 	`, dbTransaction.Entries)
 	if err != nil {
 		return err
 	}
 
-	for _, acc := range accounts {
-		account := newAccountFromPelucio(acc)
-		m := map[string]interface{}{
-			"id":          account.ID,
-			"balance":     account.Balance,
-			"version":     account.Version,
-			"updated_at":  account.UpdatedAt,
-			"new_version": time.Now().UnixNano(),
-		}
-		res, err := tx.NamedExecContext(ctx, `
-			UPDATE accounts SET balance = :balance, 
-								version = :new_version, 
-								updated_at = :updated_at 
-			WHERE id = :id AND version = :version
-		`, m)
-		if err != nil {
+	for _, account := range accounts {
+		if err := rw.bumpAccountVersion(ctx, account); err != nil {
 			return err
 		}
+	}
 
-		if rowsAffected, err := res.RowsAffected(); rowsAffected != 1 || err != nil {
-			return pelucio.ErrNotFound
-		}
+	deltas := balanceDeltas(&dbTransaction.Transaction)
+	if err := rw.incrementBalances(ctx, deltas); err != nil {
+		return err
+	}
+
+	if err := rw.writeLedgerEvents(ctx, &dbTransaction.Transaction, deltas); err != nil {
+		return err
 	}
 
-	return tx.Commit()
+	return rw.notifyTransaction(ctx, &dbTransaction.Transaction, deltas)
 }
 
 func (rw *ReadWriterPG) ReadAccount(ctx context.Context, accountID uuid.UUID) (*pelucio.Account, error) {
 	var account account
-	err := rw.DB.GetContext(ctx, &account, "SELECT * FROM accounts WHERE id = $1", accountID)
+	err := rw.getContext(ctx, rw.ext(), &account, "SELECT "+rw.accountColumns()+" FROM "+rw.table("accounts")+" WHERE id = $1", accountID)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, pelucio.ErrNotFound
 	}
@@ -328,7 +562,7 @@ func (rw *ReadWriterPG) ReadAccount(ctx context.Context, accountID uuid.UUID) (*
 
 func (rw *ReadWriterPG) ReadAccountByExternalID(ctx context.Context, externalID string) (*pelucio.Account, error) {
 	var account account
-	err := rw.DB.GetContext(ctx, &account, "SELECT * FROM accounts WHERE external_id = $1", externalID)
+	err := rw.getContext(ctx, rw.ext(), &account, "SELECT "+rw.accountColumns()+" FROM "+rw.table("accounts")+" WHERE external_id = $1", externalID)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, pelucio.ErrNotFound
 	}
@@ -345,17 +579,28 @@ func (rw *ReadWriterPG) ReadAccountByExternalID(ctx context.Context, externalID
 }
 
 func (rw *ReadWriterPG) ReadAccounts(ctx context.Context, filter pelucio.ReadAccountFilter) ([]*pelucio.Account, *string, error) {
+	page, err := rw.ReadAccountsPage(ctx, filter, PageOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return page.Items, page.tail, nil
+}
+
+// ReadAccountsPage is ReadAccounts returning tokens to both neighbour pages.
+// Accounts can only be sorted by created_at.
+func (rw *ReadWriterPG) ReadAccountsPage(ctx context.Context, filter pelucio.ReadAccountFilter, opts PageOptions) (*Page[*pelucio.Account], error) {
+	k, err := rw.newKeyset("accounts", accountsFingerprint(filter), []SortKey{SortByCreatedAt}, opts, filter.PaginationToken, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+
 	conditions := []string{}
 	args := []interface{}{}
 
-	if filter.PaginationToken != nil {
-		createdAt, id, err := decodePaginationToken(*filter.PaginationToken)
-		if err != nil {
-			return nil, nil, err
-		}
-		conditions = append(conditions, "(created_at < ? AND id > ?)")
-		args = append(args, createdAt)
-		args = append(args, id)
+	if q, argss, ok := k.condition(); ok {
+		conditions = append(conditions, q)
+		args = append(args, argss...)
 	}
 	if filter.FromDate != nil {
 		conditions = append(conditions, "created_at >= ?")
@@ -376,45 +621,38 @@ func (rw *ReadWriterPG) ReadAccounts(ctx context.Context, filter pelucio.ReadAcc
 		args = append(args, argss...)
 	}
 
-	query := "SELECT * FROM accounts "
+	query := "SELECT " + rw.accountColumns() + " FROM " + rw.table("accounts") + " AS accounts "
 	if len(conditions) > 0 {
 		query += " WHERE " + strings.Join(conditions, " AND ")
 	}
 
-	query += " ORDER BY created_at DESC, id ASC "
-	if filter.Limit != nil {
-		query += " LIMIT ?"
-		args = append(args, filter.Limit)
-	}
+	orderBy, argss := k.orderBy()
+	query += orderBy
+	args = append(args, argss...)
 	query = rw.DB.Rebind(query)
 
 	accounts := []*account{}
-	err := rw.DB.SelectContext(ctx, &accounts, query, args...)
+	err = rw.selectContext(ctx, rw.ext(), &accounts, query, args...)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	var res []*pelucio.Account
-	for _, acc := range accounts {
-		a, err := acc.ToAccount()
+	res := make([]*pelucio.Account, len(accounts))
+	for i, acc := range accounts {
+		res[i], err = acc.ToAccount()
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
-		res = append(res, a)
-	}
-
-	var paginationToken *string
-	if len(res) > 0 && filter.Limit != nil {
-		s := generatePaginationToken(res[len(res)-1].CreatedAt, res[len(res)-1].ID)
-		paginationToken = &s
 	}
 
-	return res, paginationToken, nil
+	return newPage(k, res, func(a *pelucio.Account) (time.Time, uuid.UUID) {
+		return a.CreatedAt, a.ID
+	}), nil
 }
 
 func (rw *ReadWriterPG) ReadTransaction(ctx context.Context, transactionID uuid.UUID) (*pelucio.Transaction, error) {
 	var dbTransaction transaction
-	err := rw.DB.GetContext(ctx, &dbTransaction, "SELECT * FROM transactions WHERE id = $1", transactionID)
+	err := rw.getContext(ctx, rw.ext(), &dbTransaction, "SELECT * FROM "+rw.table("transactions")+" WHERE id = $1", transactionID)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, pelucio.ErrNotFound
 	}
@@ -435,39 +673,74 @@ func (rw *ReadWriterPG) ReadTransaction(ctx context.Context, transactionID uuid.
 
 func (rw *ReadWriterPG) ReadTransactionByExternalID(ctx context.Context, externalID string) (*pelucio.Transaction, error) {
 	var transaction transaction
-	err := rw.DB.GetContext(ctx, &transaction, "SELECT * FROM transactions WHERE external_id = $1", externalID)
+	err := rw.getContext(ctx, rw.ext(), &transaction, "SELECT * FROM "+rw.table("transactions")+" WHERE external_id = $1", externalID)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, pelucio.ErrNotFound
 	}
 	if err != nil {
 		return nil, err
 	}
-	return transaction.ToTransaction(), nil
+
+	entries, err := rw.ReadEntriesOfTransaction(ctx, transaction.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := transaction.ToTransaction()
+	res.Entries = entries
+
+	return res, nil
 }
 
 func (rw *ReadWriterPG) ReadTransactions(ctx context.Context, filter pelucio.ReadTransactionFilter) ([]*pelucio.Transaction, *string, error) {
+	page, err := rw.ReadTransactionsPage(ctx, filter, PageOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return page.Items, page.tail, nil
+}
+
+// ReadTransactionsPage is ReadTransactions returning tokens to both neighbour
+// pages. Transactions can be sorted by created_at or executed_at.
+func (rw *ReadWriterPG) ReadTransactionsPage(ctx context.Context, filter pelucio.ReadTransactionFilter, opts PageOptions) (*Page[*pelucio.Transaction], error) {
+	page, err := rw.readTransactionsPage(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := rw.loadEntries(ctx, page.Items); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// readTransactionsPage is ReadTransactionsPage without the entries of the
+// transactions.
+func (rw *ReadWriterPG) readTransactionsPage(ctx context.Context, filter pelucio.ReadTransactionFilter, opts PageOptions) (*Page[*pelucio.Transaction], error) {
+	k, err := rw.newKeyset("transactions", transactionsFingerprint(filter), []SortKey{SortByCreatedAt, SortByExecutedAt}, opts, filter.PaginationToken, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+
 	conditions := []string{}
 	args := []interface{}{}
 
-	if filter.PaginationToken != nil {
-		createdAt, id, err := decodePaginationToken(*filter.PaginationToken)
-		if err != nil {
-			return nil, nil, err
-		}
-		conditions = append(conditions, "(transactions.created_at < ? AND transactions.id > ?)")
-		args = append(args, createdAt)
-		args = append(args, id)
+	if q, argss, ok := k.condition(); ok {
+		conditions = append(conditions, q)
+		args = append(args, argss...)
 	}
 	if filter.FromDate != nil {
 		conditions = append(conditions, "transactions.created_at >= ?")
 		args = append(args, filter.FromDate)
 	}
 	if filter.ToDate != nil {
-		conditions = append(conditions, "created_at <= ?")
+		conditions = append(conditions, "transactions.created_at <= ?")
 		args = append(args, filter.ToDate)
 	}
 	if len(filter.AccountIDs) > 0 {
-		q, argss, _ := sqlx.In("entries.account_id IN (?)", filter.AccountIDs)
+		q, argss, _ := sqlx.In("EXISTS (SELECT 1 FROM "+rw.table("entries")+" AS entries WHERE entries.transaction_id = transactions.id AND entries.account_id IN (?))", filter.AccountIDs)
 		conditions = append(conditions, q)
 		args = append(args, argss...)
 	}
@@ -476,39 +749,73 @@ func (rw *ReadWriterPG) ReadTransactions(ctx context.Context, filter pelucio.Rea
 		conditions = append(conditions, q)
 		args = append(args, argss...)
 	}
-	query := "SELECT transactions.* FROM transactions LEFT JOIN entries ON transactions.id = entries.transaction_id "
+	query := "SELECT transactions.* FROM " + rw.table("transactions") + " AS transactions "
 	if len(conditions) > 0 {
 		query += " WHERE " + strings.Join(conditions, " AND ")
 	}
-	query += " ORDER BY transactions.created_at DESC, transactions.id ASC"
-	if filter.Limit != nil {
-		query += " LIMIT ?"
-		args = append(args, filter.Limit)
-	}
+
+	orderBy, argss := k.orderBy()
+	query += orderBy
+	args = append(args, argss...)
 	query = rw.DB.Rebind(query)
 
 	transactionsDB := []*transaction{}
-	err := rw.DB.SelectContext(ctx, &transactionsDB, query, args...)
+	err = rw.selectContext(ctx, rw.ext(), &transactionsDB, query, args...)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	res := make([]*pelucio.Transaction, len(transactionsDB))
 	for i, t := range transactionsDB {
 		res[i] = t.ToTransaction()
 	}
-	var paginationToken *string
-	if len(res) > 0 && filter.Limit != nil {
-		s := generatePaginationToken(res[len(res)-1].CreatedAt, res[len(res)-1].ID)
-		paginationToken = &s
+
+	page := newPage(k, res, func(t *pelucio.Transaction) (time.Time, uuid.UUID) {
+		if k.sortKey == SortByExecutedAt && t.ExecutedAt != nil {
+			return *t.ExecutedAt, t.ID
+		}
+		return t.CreatedAt, t.ID
+	})
+
+	return page, nil
+}
+
+// loadEntries fills the entries of transactions with a single query.
+func (rw *ReadWriterPG) loadEntries(ctx context.Context, transactions []*pelucio.Transaction) error {
+	if len(transactions) == 0 {
+		return nil
+	}
+
+	byID := make(map[uuid.UUID]*pelucio.Transaction, len(transactions))
+	ids := make([]uuid.UUID, 0, len(transactions))
+	for _, t := range transactions {
+		if _, ok := byID[t.ID]; !ok {
+			byID[t.ID] = t
+			ids = append(ids, t.ID)
+		}
+		t.Entries = nil
+	}
+
+	entriesdb := []*entry{}
+	err := rw.selectContext(ctx, rw.ext(), &entriesdb,
+		"SELECT * FROM "+rw.table("entries")+" WHERE transaction_id = ANY($1::uuid[]) ORDER BY created_at, id",
+		pq.Array(xuuid.ToStrings(ids...)))
+	if err != nil {
+		return err
 	}
 
-	return res, paginationToken, err
+	for _, e := range entriesdb {
+		if t, ok := byID[e.TransactionID]; ok {
+			t.Entries = append(t.Entries, e.ToEntry())
+		}
+	}
+
+	return nil
 }
 
 func (rw *ReadWriterPG) ReadEntriesOfAccount(ctx context.Context, accountID uuid.UUID) ([]*pelucio.Entry, error) {
 	entriesdb := []*entry{}
-	err := rw.DB.SelectContext(ctx, &entriesdb, "SELECT * FROM entries WHERE account_id = $1", accountID)
+	err := rw.selectContext(ctx, rw.ext(), &entriesdb, "SELECT * FROM "+rw.table("entries")+" WHERE account_id = $1", accountID)
 	if err != nil {
 		return nil, err
 	}
@@ -522,16 +829,27 @@ func (rw *ReadWriterPG) ReadEntriesOfAccount(ctx context.Context, accountID uuid
 }
 
 func (rw *ReadWriterPG) ReadEntries(ctx context.Context, filter pelucio.ReadEntryFilter) ([]*pelucio.Entry, *string, error) {
+	page, err := rw.ReadEntriesPage(ctx, filter, PageOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return page.Items, page.tail, nil
+}
+
+// ReadEntriesPage is ReadEntries returning tokens to both neighbour pages.
+// Entries can only be sorted by created_at.
+func (rw *ReadWriterPG) ReadEntriesPage(ctx context.Context, filter pelucio.ReadEntryFilter, opts PageOptions) (*Page[*pelucio.Entry], error) {
+	k, err := rw.newKeyset("entries", entriesFingerprint(filter), []SortKey{SortByCreatedAt}, opts, filter.PaginationToken, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+
 	conditions := []string{}
 	args := []interface{}{}
-	if filter.PaginationToken != nil {
-		createdAt, id, err := decodePaginationToken(*filter.PaginationToken)
-		if err != nil {
-			return nil, nil, err
-		}
-		conditions = append(conditions, "(created_at < ? AND id > ?)")
-		args = append(args, createdAt)
-		args = append(args, id)
+	if q, argss, ok := k.condition(); ok {
+		conditions = append(conditions, q)
+		args = append(args, argss...)
 	}
 	if filter.FromDate != nil {
 		conditions = append(conditions, "created_at >= ?")
@@ -551,22 +869,20 @@ func (rw *ReadWriterPG) ReadEntries(ctx context.Context, filter pelucio.ReadEntr
 		conditions = append(conditions, query)
 		args = append(args, argss...)
 	}
-	query := "SELECT * FROM entries "
+	query := "SELECT * FROM " + rw.table("entries") + " AS entries "
 	if len(conditions) > 0 {
 		query += " WHERE " + strings.Join(conditions, " AND ")
 	}
 
-	query += " ORDER BY created_at DESC, id ASC"
-	if filter.Limit != nil {
-		query += " LIMIT ?"
-		args = append(args, filter.Limit)
-	}
+	orderBy, argss := k.orderBy()
+	query += orderBy
+	args = append(args, argss...)
 	query = rw.DB.Rebind(query)
 
 	entriesdb := []*entry{}
-	err := rw.DB.SelectContext(ctx, &entriesdb, query, args...)
+	err = rw.selectContext(ctx, rw.ext(), &entriesdb, query, args...)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	res := make([]*pelucio.Entry, len(entriesdb))
@@ -574,17 +890,14 @@ func (rw *ReadWriterPG) ReadEntries(ctx context.Context, filter pelucio.ReadEntr
 		res[i] = e.ToEntry()
 	}
 
-	var paginationToken *string
-	if len(res) > 0 && filter.Limit != nil {
-		s := generatePaginationToken(res[len(res)-1].CreatedAt, res[len(res)-1].ID)
-		paginationToken = &s
-	}
-	return res, paginationToken, err
+	return newPage(k, res, func(e *pelucio.Entry) (time.Time, uuid.UUID) {
+		return e.CreatedAt, e.ID
+	}), nil
 }
 
 func (rw *ReadWriterPG) ReadEntriesOfTransaction(ctx context.Context, transactionID uuid.UUID) ([]*pelucio.Entry, error) {
 	entriesdb := []*entry{}
-	err := rw.DB.SelectContext(ctx, &entriesdb, "SELECT * FROM entries WHERE transaction_id = $1", transactionID)
+	err := rw.selectContext(ctx, rw.ext(), &entriesdb, "SELECT * FROM "+rw.table("entries")+" WHERE transaction_id = $1", transactionID)
 	if err != nil {
 		return nil, err
 	}
@@ -596,34 +909,3 @@ func (rw *ReadWriterPG) ReadEntriesOfTransaction(ctx context.Context, transactio
 
 	return entries, err
 }
-
-func generatePaginationToken(createdAt time.Time, id uuid.UUID) string {
-	token := fmt.Sprintf("%s|%s", createdAt.Format(time.RFC3339Nano), id.String())
-	token = base64.StdEncoding.EncodeToString([]byte(token))
-	return token
-}
-
-func decodePaginationToken(paginationToken string) (createdAt time.Time, id uuid.UUID, err error) {
-	token, err := base64.StdEncoding.DecodeString(paginationToken)
-	if err != nil {
-		return
-	}
-
-	splittedToken := strings.Split(string(token), "|")
-	if len(splittedToken) != 2 {
-		err = errors.New("unexpected token length")
-		return
-	}
-
-	createdAt, err = time.Parse(time.RFC3339Nano, splittedToken[0])
-	if err != nil {
-		return
-	}
-
-	id, err = uuid.FromString(splittedToken[1])
-	if err != nil {
-		return
-	}
-
-	return
-}
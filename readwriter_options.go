@@ -0,0 +1,143 @@
+package peluciopg
+
+import (
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/devmalloni/pelucio/x/xtime"
+)
+
+type ReadWriterPGOpt func(p *ReadWriterPG)
+
+// WithMaxOpenConns sets the maximum number of open connections of the underlying pool.
+func WithMaxOpenConns(n int) ReadWriterPGOpt {
+	return func(p *ReadWriterPG) {
+		p.DB.SetMaxOpenConns(n)
+	}
+}
+
+// WithMaxIdleConns sets the maximum number of idle connections of the underlying pool.
+func WithMaxIdleConns(n int) ReadWriterPGOpt {
+	return func(p *ReadWriterPG) {
+		p.DB.SetMaxIdleConns(n)
+	}
+}
+
+// WithConnMaxLifetime sets the maximum amount of time a connection may be reused.
+func WithConnMaxLifetime(d time.Duration) ReadWriterPGOpt {
+	return func(p *ReadWriterPG) {
+		p.DB.SetConnMaxLifetime(d)
+	}
+}
+
+// WithStatementTimeout bounds every statement issued by the ReadWriterPG.
+// Statements already running under a shorter context deadline keep it.
+func WithStatementTimeout(d time.Duration) ReadWriterPGOpt {
+	return func(p *ReadWriterPG) {
+		p.statementTimeout = d
+	}
+}
+
+// WithSchema makes every query target the tables of the given schema
+// instead of the ones resolved by the connection search_path.
+func WithSchema(schema string) ReadWriterPGOpt {
+	return func(p *ReadWriterPG) {
+		p.schema = schema
+	}
+}
+
+// WithLogger sets the logger the ReadWriterPG reports its statements and
+// background errors to. Nothing is logged without one.
+func WithLogger(logger *slog.Logger) ReadWriterPGOpt {
+	return func(p *ReadWriterPG) {
+		p.logger = logger
+	}
+}
+
+// WithClock sets the clock the ReadWriterPG takes the timestamps and versions
+// it writes from.
+func WithClock(clock xtime.Clock) ReadWriterPGOpt {
+	return func(p *ReadWriterPG) {
+		p.clock = clock
+	}
+}
+
+// WithIsolationLevel sets the isolation level of the transactions opened by
+// the ReadWriterPG.
+func WithIsolationLevel(level sql.IsolationLevel) ReadWriterPGOpt {
+	return func(p *ReadWriterPG) {
+		p.isolationLevel = level
+	}
+}
+
+// WithRetryPolicy makes WriteTransaction retry on serialization failures,
+// deadlocks and version conflicts. See RetryPolicy.
+func WithRetryPolicy(policy RetryPolicy) ReadWriterPGOpt {
+	return func(p *ReadWriterPG) {
+		p.retryPolicy = policy
+	}
+}
+
+// WithIdempotentWrites makes WriteTransaction behave as
+// WriteTransactionIdempotent, so retried requests are safe to replay.
+func WithIdempotentWrites() ReadWriterPGOpt {
+	return func(p *ReadWriterPG) {
+		p.idempotentWrites = true
+	}
+}
+
+// WithPaginationKey sets the key pagination tokens are signed with. Tokens
+// are only accepted by ReadWriterPGs sharing the key; without one, a random
+// key is used per process.
+func WithPaginationKey(key []byte) ReadWriterPGOpt {
+	return func(p *ReadWriterPG) {
+		p.paginationKey = key
+	}
+}
+
+// WithPaginationTokenTTL sets for how long pagination tokens are accepted
+// after being issued. Defaults to DefaultPaginationTokenTTL.
+func WithPaginationTokenTTL(d time.Duration) ReadWriterPGOpt {
+	return func(p *ReadWriterPG) {
+		p.paginationTokenTTL = d
+	}
+}
+
+// WithOutbox makes transaction writes record ledger events in the
+// ledger_events table, in the same database transaction as the entries. See
+// EventRelay for delivering them.
+func WithOutbox() ReadWriterPGOpt {
+	return func(p *ReadWriterPG) {
+		p.outbox = true
+	}
+}
+
+// WithNotifications makes transaction writes pg_notify channel with the
+// transaction ID and the balance changes it made, for Subscribe. An empty
+// channel means DefaultNotifyChannel.
+func WithNotifications(channel string) ReadWriterPGOpt {
+	return func(p *ReadWriterPG) {
+		if channel == "" {
+			channel = DefaultNotifyChannel
+		}
+		p.notifyChannel = channel
+	}
+}
+
+// WithListenerDSN sets the connection string Subscribe opens its listener
+// connection with. NewReadWriterPG sets it to the dsn it connects to.
+func WithListenerDSN(dsn string) ReadWriterPGOpt {
+	return func(p *ReadWriterPG) {
+		p.listenerDSN = dsn
+	}
+}
+
+// WithConcurrencyMode sets how concurrent writes on the same accounts are
+// handled. Defaults to OptimisticLocking; PessimisticLocking suits hot
+// accounts, which most writes would otherwise conflict on.
+func WithConcurrencyMode(mode ConcurrencyMode) ReadWriterPGOpt {
+	return func(p *ReadWriterPG) {
+		p.concurrencyMode = mode
+	}
+}
@@ -0,0 +1,83 @@
+package peluciopg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/devmalloni/pelucio"
+	"github.com/devmalloni/pelucio/x/xtime"
+	"github.com/devmalloni/pelucio/x/xuuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewReadWriterPGFromDB_Options(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open sqlmock database: %v", err)
+	}
+	defer db.Close()
+
+	clock := xtime.NewStubClock(time.Now())
+	rw := NewReadWriterPGFromDB(db,
+		WithMaxOpenConns(7),
+		WithSchema("ledger"),
+		WithStatementTimeout(time.Second),
+		WithClock(clock))
+
+	assert.Equal(t, 7, rw.DB.Stats().MaxOpenConnections)
+	assert.Equal(t, "ledger", rw.schema)
+	assert.Equal(t, time.Second, rw.statementTimeout)
+	assert.Equal(t, clock.Now(), rw.now())
+}
+
+func TestReadAccount_WithSchema(t *testing.T) {
+	rw, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	WithSchema("ledger")(rw)
+
+	accID := xuuid.New()
+	mock.ExpectQuery(`SELECT (.+) FROM "ledger".accounts WHERE id = \$1`).
+		WithArgs(accID).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	_, err := rw.ReadAccount(context.Background(), accID)
+	assert.ErrorIs(t, err, pelucio.ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReadAccount_WithStatementTimeout(t *testing.T) {
+	rw, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	WithStatementTimeout(10 * time.Millisecond)(rw)
+
+	accID := xuuid.New()
+	mock.ExpectQuery(`SELECT (.+) FROM accounts WHERE id = \$1`).
+		WithArgs(accID).
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	_, err := rw.ReadAccount(context.Background(), accID)
+	assert.Error(t, err)
+}
+
+func TestWriteAccount_WithClock(t *testing.T) {
+	rw, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	clock := xtime.NewStubClock(time.Now())
+	WithClock(clock)(rw)
+
+	acc := pelucio.NewAccount(clock,
+		pelucio.WithExternalID("extid"),
+		pelucio.WithName("test"),
+		pelucio.WithNormalSide(pelucio.Debit))
+
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs(acc.ID, acc.ExternalID, acc.Name, acc.NormalSide, sqlmock.AnyArg(), clock.Now().UnixNano(), acc.CreatedAt).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err := rw.WriteAccount(context.Background(), acc, false)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
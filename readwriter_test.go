@@ -2,7 +2,10 @@ package peluciopg
 
 import (
 	"context"
+	"database/sql/driver"
+	"errors"
 	"math/big"
+	"regexp"
 	"testing"
 	"time"
 
@@ -39,7 +42,7 @@ func TestWriteAccount_Insert(t *testing.T) {
 	}
 
 	mock.ExpectExec("INSERT INTO accounts").
-		WithArgs(acc.ID, acc.ExternalID, sqlmock.AnyArg(), acc.Name, acc.NormalSide, sqlmock.AnyArg(), sqlmock.AnyArg(), acc.CreatedAt).
+		WithArgs(acc.ID, acc.ExternalID, acc.Name, acc.NormalSide, sqlmock.AnyArg(), sqlmock.AnyArg(), acc.CreatedAt).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	err := db.WriteAccount(context.Background(), acc, false)
@@ -59,19 +62,18 @@ func TestWriteAccount_Upsert(t *testing.T) {
 		CreatedAt:  time.Now(),
 	}
 
-	mock.ExpectExec("INSERT INTO accounts .* UPDATE SET").
+	mock.ExpectQuery("INSERT INTO accounts .* UPDATE SET .* RETURNING xmax = 0 AS inserted").
 		WithArgs(acc.ID,
 			acc.ExternalID,
 			acc.Name,
 			sqlmock.AnyArg(), // metadata
 			acc.NormalSide,
 			sqlmock.AnyArg(), // version
-			sqlmock.AnyArg(), // balance
 			acc.CreatedAt,
 			acc.UpdatedAt,
 			acc.DeletedAt,
 			sqlmock.AnyArg()). // new_version
-		WillReturnResult(sqlmock.NewResult(1, 1))
+		WillReturnRows(sqlmock.NewRows([]string{"inserted"}).AddRow(true))
 
 	err := db.WriteAccount(context.Background(), acc, true)
 	assert.NoError(t, err)
@@ -130,7 +132,6 @@ func TestWriteTransaction(t *testing.T) {
 
 	mock.ExpectExec("UPDATE accounts").
 		WithArgs(
-			sqlmock.AnyArg(),
 			sqlmock.AnyArg(),
 			firstAccount.UpdatedAt,
 			firstAccount.ID,
@@ -140,7 +141,6 @@ func TestWriteTransaction(t *testing.T) {
 
 	mock.ExpectExec("UPDATE accounts").
 		WithArgs(
-			sqlmock.AnyArg(),
 			sqlmock.AnyArg(),
 			secondAccount.UpdatedAt,
 			secondAccount.ID,
@@ -148,6 +148,7 @@ func TestWriteTransaction(t *testing.T) {
 		).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
+	mock.ExpectExec("INSERT INTO account_balances").WillReturnResult(sqlmock.NewResult(1, 2))
 	mock.ExpectCommit()
 
 	err := db.WriteTransaction(context.Background(), transaction, firstAccount, secondAccount)
@@ -242,7 +243,7 @@ func TestReadAccounts(t *testing.T) {
 		AddRow(firstAccount.ID, firstAccount.ExternalID, firstAccount.Name, []byte("{}"), firstAccount.NormalSide, int64(1), []byte("{\"BRL\": 100 }"), firstAccount.CreatedAt, firstAccount.UpdatedAt, firstAccount.DeletedAt).
 		AddRow(secondAccount.ID, secondAccount.ExternalID, secondAccount.Name, []byte("{}"), secondAccount.NormalSide, int64(1), []byte("{\"BRL\": 100 }"), secondAccount.CreatedAt, secondAccount.UpdatedAt, secondAccount.DeletedAt)
 
-	mock.ExpectQuery("SELECT (.+) FROM accounts (.+) ORDER BY created_at DESC").
+	mock.ExpectQuery("SELECT (.+) FROM accounts (.+) ORDER BY accounts.created_at DESC").
 		WithArgs(filter.FromDate, filter.ToDate, filter.AccountIDs[0], filter.ExternalIDs[0], filter.ExternalIDs[1]).
 		WillReturnRows(txRows)
 
@@ -258,6 +259,7 @@ func TestReadAccounts(t *testing.T) {
 func TestReadAccounts_Paginated(t *testing.T) {
 	db, mock, cleanup := setupMockDB(t)
 	defer cleanup()
+	WithClock(xtime.NewStubClock(time.Now()))(db)
 
 	limit := uint(1)
 	filter := pelucio.ReadAccountFilter{
@@ -277,11 +279,11 @@ func TestReadAccounts_Paginated(t *testing.T) {
 		NewRows([]string{"id", "external_id", "name", "metadata", "normal_side", "version", "balance", "created_at", "updated_at", "deleted_at"}).
 		AddRow(firstAccount.ID, firstAccount.ExternalID, firstAccount.Name, []byte("{}"), firstAccount.NormalSide, int64(1), []byte("{\"BRL\": 100 }"), firstAccount.CreatedAt, firstAccount.UpdatedAt, firstAccount.DeletedAt)
 
-	mock.ExpectQuery("SELECT (.+) FROM accounts (.+) ORDER BY created_at DESC, id ASC LIMIT").
-		WithArgs(filter.FromDate, filter.ToDate, filter.AccountIDs[0], filter.ExternalIDs[0], filter.ExternalIDs[1], filter.Limit).
+	mock.ExpectQuery("SELECT (.+) FROM accounts (.+) ORDER BY (.+)created_at DESC, (.+)id DESC LIMIT").
+		WithArgs(filter.FromDate, filter.ToDate, filter.AccountIDs[0], filter.ExternalIDs[0], filter.ExternalIDs[1], *filter.Limit+1).
 		WillReturnRows(txRows)
 
-	expectedPaginationToken := generatePaginationToken(firstAccount.CreatedAt, firstAccount.ID)
+	expectedPaginationToken := nextPageToken(db, accountsFingerprint(filter), firstAccount.CreatedAt, firstAccount.ID)
 	resultTxs, paginationToken, err := db.ReadAccounts(context.Background(), filter)
 	assert.NoError(t, err)
 	assert.Equal(t, *paginationToken, expectedPaginationToken)
@@ -293,20 +295,22 @@ func TestReadAccounts_Paginated(t *testing.T) {
 func TestReadAccounts_PaginatedWithPaginationToken(t *testing.T) {
 	db, mock, cleanup := setupMockDB(t)
 	defer cleanup()
+	WithClock(xtime.NewStubClock(time.Now()))(db)
 
 	limit := uint(1)
 	createdAt := time.Now()
 	id := xuuid.New()
-	token := generatePaginationToken(createdAt, id)
-	createdAt, id, _ = decodePaginationToken(token)
 	filter := pelucio.ReadAccountFilter{
-		FromDate:        xtime.DefaultClock.NilNow(),
-		ToDate:          xtime.DefaultClock.NilNow(),
-		AccountIDs:      []string{xuuid.New().String()},
-		ExternalIDs:     []string{"external1", "external2"},
-		Limit:           &limit,
-		PaginationToken: &token,
+		FromDate:    xtime.DefaultClock.NilNow(),
+		ToDate:      xtime.DefaultClock.NilNow(),
+		AccountIDs:  []string{xuuid.New().String()},
+		ExternalIDs: []string{"external1", "external2"},
+		Limit:       &limit,
 	}
+	token := nextPageToken(db, accountsFingerprint(filter), createdAt, id)
+	cursor, _ := db.decodePaginationToken(token, accountsFingerprint(filter))
+	createdAt, id = cursor.Key, cursor.ID
+	filter.PaginationToken = &token
 
 	firstAccount := pelucio.NewAccount(xtime.DefaultClock,
 		pelucio.WithExternalID("external1"),
@@ -317,11 +321,11 @@ func TestReadAccounts_PaginatedWithPaginationToken(t *testing.T) {
 		NewRows([]string{"id", "external_id", "name", "metadata", "normal_side", "version", "balance", "created_at", "updated_at", "deleted_at"}).
 		AddRow(firstAccount.ID, firstAccount.ExternalID, firstAccount.Name, []byte("{}"), firstAccount.NormalSide, int64(1), []byte("{\"BRL\": 100 }"), firstAccount.CreatedAt, firstAccount.UpdatedAt, firstAccount.DeletedAt)
 
-	mock.ExpectQuery("SELECT (.+) FROM accounts (.+) ORDER BY created_at DESC, id ASC LIMIT").
-		WithArgs(createdAt, id, filter.FromDate, filter.ToDate, filter.AccountIDs[0], filter.ExternalIDs[0], filter.ExternalIDs[1], filter.Limit).
+	mock.ExpectQuery("SELECT (.+) FROM accounts (.+) ORDER BY (.+)created_at DESC, (.+)id DESC LIMIT").
+		WithArgs(createdAt, id, filter.FromDate, filter.ToDate, filter.AccountIDs[0], filter.ExternalIDs[0], filter.ExternalIDs[1], *filter.Limit+1).
 		WillReturnRows(txRows)
 
-	expectedPaginationToken := generatePaginationToken(firstAccount.CreatedAt, firstAccount.ID)
+	expectedPaginationToken := nextPageToken(db, accountsFingerprint(filter), firstAccount.CreatedAt, firstAccount.ID)
 	resultTxs, paginationToken, err := db.ReadAccounts(context.Background(), filter)
 	assert.NoError(t, err)
 	assert.Equal(t, *paginationToken, expectedPaginationToken)
@@ -387,11 +391,20 @@ func TestReadTransactionByExternalID(t *testing.T) {
 		WithArgs(transaction.ExternalID).
 		WillReturnRows(txRows)
 
+	entryRows := sqlmock.NewRows([]string{"id", "transaction_id", "account_id", "entry_side", "account_side", "amount", "currency", "created_at"})
+	for _, e := range transaction.Entries {
+		entryRows.AddRow(e.ID, e.TransactionID, e.AccountID, e.EntrySide, e.AccountSide, "100", e.Currency, e.CreatedAt)
+	}
+	mock.ExpectQuery("SELECT (.+) FROM entries WHERE transaction_id = \\$1").
+		WithArgs(transaction.ID).
+		WillReturnRows(entryRows)
+
 	resultTx, err := db.ReadTransactionByExternalID(context.Background(), transaction.ExternalID)
 	assert.NoError(t, err)
 	assert.Equal(t, transaction.ID, resultTx.ID)
 	assert.Equal(t, transaction.ExternalID, resultTx.ExternalID)
 	assert.Equal(t, transaction.Description, resultTx.Description)
+	assert.Len(t, resultTx.Entries, 2)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
@@ -414,22 +427,35 @@ func TestReadTransactions(t *testing.T) {
 		AddRow(firstTx.ID, firstTx.ExternalID, firstTx.Description, []byte("{}"), firstTx.CreatedAt).
 		AddRow(secondTx.ID, secondTx.ExternalID, secondTx.Description, []byte("{}"), secondTx.CreatedAt)
 
-	mock.ExpectQuery("SELECT (.+) FROM transactions LEFT JOIN entries ON transactions.id = entries.transaction_id (.+) ORDER BY transactions.created_at DESC").
+	mock.ExpectQuery("SELECT (.+) FROM transactions AS transactions WHERE (.+) EXISTS \\(SELECT 1 FROM entries AS entries WHERE entries.transaction_id = transactions.id AND entries.account_id IN (.+) ORDER BY transactions.created_at DESC").
 		WithArgs(filter.FromDate, filter.ToDate, filter.AccountIDs[0], filter.ExternalIDs[0], filter.ExternalIDs[1]).
 		WillReturnRows(txRows)
 
+	// entries of the whole page come in one query
+	entryRows := sqlmock.NewRows([]string{"id", "transaction_id", "account_id", "entry_side", "account_side", "amount", "currency", "created_at"})
+	for _, e := range append(firstTx.Entries, secondTx.Entries...) {
+		entryRows.AddRow(e.ID, e.TransactionID, e.AccountID, e.EntrySide, e.AccountSide, "100", e.Currency, e.CreatedAt)
+	}
+	mock.ExpectQuery("SELECT \\* FROM entries WHERE transaction_id = ANY\\(\\$1::uuid\\[\\]\\)").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(entryRows)
+
 	resultTxs, paginationToken, err := db.ReadTransactions(context.Background(), filter)
 	assert.NoError(t, err)
 	assert.Nil(t, paginationToken)
 	assert.Len(t, resultTxs, 2)
 	assert.Equal(t, firstTx.ID, resultTxs[0].ID)
 	assert.Equal(t, secondTx.ID, resultTxs[1].ID)
+	assert.Len(t, resultTxs[0].Entries, 2)
+	assert.Len(t, resultTxs[1].Entries, 2)
+	assert.Equal(t, secondTx.ID, resultTxs[1].Entries[0].TransactionID)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
 func TestReadTransactions_Paginated(t *testing.T) {
 	db, mock, cleanup := setupMockDB(t)
 	defer cleanup()
+	WithClock(xtime.NewStubClock(time.Now()))(db)
 
 	limit := uint(1)
 	filter := pelucio.ReadTransactionFilter{
@@ -446,11 +472,13 @@ func TestReadTransactions_Paginated(t *testing.T) {
 		NewRows([]string{"id", "external_id", "description", "metadata", "created_at"}).
 		AddRow(firstTx.ID, firstTx.ExternalID, firstTx.Description, []byte("{}"), firstTx.CreatedAt)
 
-	mock.ExpectQuery("SELECT (.+) FROM transactions LEFT JOIN entries ON transactions.id = entries.transaction_id (.+) ORDER BY transactions.created_at DESC, transactions.id ASC LIMIT").
-		WithArgs(filter.FromDate, filter.ToDate, filter.AccountIDs[0], filter.ExternalIDs[0], filter.ExternalIDs[1], filter.Limit).
+	mock.ExpectQuery("SELECT (.+) FROM transactions AS transactions WHERE (.+) EXISTS \\(SELECT 1 FROM entries AS entries WHERE entries.transaction_id = transactions.id AND entries.account_id IN (.+) ORDER BY transactions.created_at DESC, transactions.id DESC LIMIT").
+		WithArgs(filter.FromDate, filter.ToDate, filter.AccountIDs[0], filter.ExternalIDs[0], filter.ExternalIDs[1], *filter.Limit+1).
 		WillReturnRows(txRows)
+	mock.ExpectQuery("SELECT \\* FROM entries WHERE transaction_id = ANY").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
 
-	expectedPaginationToken := generatePaginationToken(firstTx.CreatedAt, firstTx.ID)
+	expectedPaginationToken := nextPageToken(db, transactionsFingerprint(filter), firstTx.CreatedAt, firstTx.ID)
 	resultTxs, paginationToken, err := db.ReadTransactions(context.Background(), filter)
 	assert.NoError(t, err)
 	assert.Equal(t, *paginationToken, expectedPaginationToken)
@@ -462,20 +490,22 @@ func TestReadTransactions_Paginated(t *testing.T) {
 func TestReadTransactions_PaginatedWithPaginationToken(t *testing.T) {
 	db, mock, cleanup := setupMockDB(t)
 	defer cleanup()
+	WithClock(xtime.NewStubClock(time.Now()))(db)
 
 	limit := uint(1)
 	lastCreatedAt := time.Now()
 	lastID := xuuid.New()
-	token := generatePaginationToken(lastCreatedAt, lastID)
-	lastCreatedAt, lastID, _ = decodePaginationToken(token)
 	filter := pelucio.ReadTransactionFilter{
-		FromDate:        xtime.DefaultClock.NilNow(),
-		ToDate:          xtime.DefaultClock.NilNow(),
-		AccountIDs:      []string{xuuid.New().String()},
-		ExternalIDs:     []string{"external1", "external2"},
-		Limit:           &limit,
-		PaginationToken: &token,
+		FromDate:    xtime.DefaultClock.NilNow(),
+		ToDate:      xtime.DefaultClock.NilNow(),
+		AccountIDs:  []string{xuuid.New().String()},
+		ExternalIDs: []string{"external1", "external2"},
+		Limit:       &limit,
 	}
+	token := nextPageToken(db, transactionsFingerprint(filter), lastCreatedAt, lastID)
+	cursor, _ := db.decodePaginationToken(token, transactionsFingerprint(filter))
+	lastCreatedAt, lastID = cursor.Key, cursor.ID
+	filter.PaginationToken = &token
 
 	firstTx := pelucio.Deposit("external1", xuuid.New(), xuuid.New(), big.NewInt(100), "USD")
 
@@ -483,11 +513,13 @@ func TestReadTransactions_PaginatedWithPaginationToken(t *testing.T) {
 		NewRows([]string{"id", "external_id", "description", "metadata", "created_at"}).
 		AddRow(firstTx.ID, firstTx.ExternalID, firstTx.Description, []byte("{}"), firstTx.CreatedAt)
 
-	mock.ExpectQuery("SELECT (.+) FROM transactions LEFT JOIN entries ON transactions.id = entries.transaction_id (.+) ORDER BY transactions.created_at DESC, transactions.id ASC LIMIT").
-		WithArgs(lastCreatedAt, lastID, filter.FromDate, filter.ToDate, filter.AccountIDs[0], filter.ExternalIDs[0], filter.ExternalIDs[1], filter.Limit).
+	mock.ExpectQuery("SELECT (.+) FROM transactions AS transactions WHERE (.+) EXISTS \\(SELECT 1 FROM entries AS entries WHERE entries.transaction_id = transactions.id AND entries.account_id IN (.+) ORDER BY transactions.created_at DESC, transactions.id DESC LIMIT").
+		WithArgs(lastCreatedAt, lastID, filter.FromDate, filter.ToDate, filter.AccountIDs[0], filter.ExternalIDs[0], filter.ExternalIDs[1], *filter.Limit+1).
 		WillReturnRows(txRows)
+	mock.ExpectQuery("SELECT \\* FROM entries WHERE transaction_id = ANY").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
 
-	expectedPaginationToken := generatePaginationToken(firstTx.CreatedAt, firstTx.ID)
+	expectedPaginationToken := nextPageToken(db, transactionsFingerprint(filter), firstTx.CreatedAt, firstTx.ID)
 	resultTxs, paginationToken, err := db.ReadTransactions(context.Background(), filter)
 	assert.NoError(t, err)
 	assert.Equal(t, *paginationToken, expectedPaginationToken)
@@ -580,7 +612,7 @@ func TestReadEntries(t *testing.T) {
 		AddRow(firstEntry.ID, firstEntry.TransactionID, firstEntry.AccountID, firstEntry.EntrySide, firstEntry.AccountSide, "100", firstEntry.Currency, firstEntry.CreatedAt).
 		AddRow(secondEntry.ID, secondEntry.TransactionID, secondEntry.AccountID, secondEntry.EntrySide, secondEntry.AccountSide, "100", secondEntry.Currency, secondEntry.CreatedAt)
 
-	mock.ExpectQuery("SELECT (.+) FROM entries (.+) ORDER BY created_at DESC").
+	mock.ExpectQuery("SELECT (.+) FROM entries (.+) ORDER BY entries.created_at DESC").
 		WithArgs(filter.FromDate, filter.ToDate, filter.AccountIDs[0], filter.TransactionIDs[0], filter.TransactionIDs[1]).
 		WillReturnRows(txRows)
 
@@ -594,8 +626,9 @@ func TestReadEntries(t *testing.T) {
 func TestReadEntries_Paginated_WithLimit(t *testing.T) {
 	db, mock, cleanup := setupMockDB(t)
 	defer cleanup()
+	WithClock(xtime.NewStubClock(time.Now()))(db)
 
-	limit := uint(1)
+	limit := uint(2)
 
 	filter := pelucio.ReadEntryFilter{
 		FromDate:       xtime.DefaultClock.NilNow(),
@@ -637,11 +670,11 @@ func TestReadEntries_Paginated_WithLimit(t *testing.T) {
 		AddRow(firstEntry.ID, firstEntry.TransactionID, firstEntry.AccountID, firstEntry.EntrySide, firstEntry.AccountSide, "100", firstEntry.Currency, firstEntry.CreatedAt).
 		AddRow(secondEntry.ID, secondEntry.TransactionID, secondEntry.AccountID, secondEntry.EntrySide, secondEntry.AccountSide, "100", secondEntry.Currency, secondEntry.CreatedAt)
 
-	mock.ExpectQuery("SELECT (.+) FROM entries (.+) ORDER BY created_at DESC, id ASC LIMIT").
-		WithArgs(filter.FromDate, filter.ToDate, filter.AccountIDs[0], filter.TransactionIDs[0], filter.TransactionIDs[1], filter.Limit).
+	mock.ExpectQuery("SELECT (.+) FROM entries (.+) ORDER BY (.+)created_at DESC, (.+)id DESC LIMIT").
+		WithArgs(filter.FromDate, filter.ToDate, filter.AccountIDs[0], filter.TransactionIDs[0], filter.TransactionIDs[1], *filter.Limit+1).
 		WillReturnRows(txRows)
 
-	expectedPaginationToken := generatePaginationToken(secondEntry.CreatedAt, secondEntry.ID)
+	expectedPaginationToken := nextPageToken(db, entriesFingerprint(filter), secondEntry.CreatedAt, secondEntry.ID)
 
 	resultTxs, paginationToken, err := db.ReadEntries(context.Background(), filter)
 	assert.NoError(t, err)
@@ -653,6 +686,7 @@ func TestReadEntries_Paginated_WithLimit(t *testing.T) {
 func TestReadEntries_Paginated_WithPaginationToken(t *testing.T) {
 	db, mock, cleanup := setupMockDB(t)
 	defer cleanup()
+	WithClock(xtime.NewStubClock(time.Now()))(db)
 
 	secondEntry := &pelucio.Entry{
 		ID:        xuuid.New(),
@@ -668,16 +702,17 @@ func TestReadEntries_Paginated_WithPaginationToken(t *testing.T) {
 	}
 
 	limit := uint(1)
-	paginationToken := generatePaginationToken(secondEntry.CreatedAt, secondEntry.ID)
-	createdAt, _, _ := decodePaginationToken(paginationToken)
 	filter := pelucio.ReadEntryFilter{
-		FromDate:        xtime.DefaultClock.NilNow(),
-		ToDate:          xtime.DefaultClock.NilNow(),
-		AccountIDs:      []string{xuuid.New().String()},
-		TransactionIDs:  []string{"external1", "external2"},
-		Limit:           &limit,
-		PaginationToken: &paginationToken,
+		FromDate:       xtime.DefaultClock.NilNow(),
+		ToDate:         xtime.DefaultClock.NilNow(),
+		AccountIDs:     []string{xuuid.New().String()},
+		TransactionIDs: []string{"external1", "external2"},
+		Limit:          &limit,
 	}
+	paginationToken := nextPageToken(db, entriesFingerprint(filter), secondEntry.CreatedAt, secondEntry.ID)
+	cursor, _ := db.decodePaginationToken(paginationToken, entriesFingerprint(filter))
+	createdAt := cursor.Key
+	filter.PaginationToken = &paginationToken
 
 	txRows := sqlmock.
 		NewRows([]string{
@@ -691,11 +726,11 @@ func TestReadEntries_Paginated_WithPaginationToken(t *testing.T) {
 			"created_at"}).
 		AddRow(thirdEntry.ID, thirdEntry.TransactionID, thirdEntry.AccountID, thirdEntry.EntrySide, thirdEntry.AccountSide, "100", thirdEntry.Currency, thirdEntry.CreatedAt)
 
-	mock.ExpectQuery("SELECT (.+) FROM entries (.+) ORDER BY created_at DESC, id ASC LIMIT").
-		WithArgs(createdAt, secondEntry.ID, filter.FromDate, filter.ToDate, filter.AccountIDs[0], filter.TransactionIDs[0], filter.TransactionIDs[1], filter.Limit).
+	mock.ExpectQuery("SELECT (.+) FROM entries (.+) ORDER BY (.+)created_at DESC, (.+)id DESC LIMIT").
+		WithArgs(createdAt, secondEntry.ID, filter.FromDate, filter.ToDate, filter.AccountIDs[0], filter.TransactionIDs[0], filter.TransactionIDs[1], *filter.Limit+1).
 		WillReturnRows(txRows)
 
-	expectedPaginationToken := generatePaginationToken(thirdEntry.CreatedAt, thirdEntry.ID)
+	expectedPaginationToken := nextPageToken(db, entriesFingerprint(filter), thirdEntry.CreatedAt, thirdEntry.ID)
 
 	resultTxs, newPaginationToken, err := db.ReadEntries(context.Background(), filter)
 	assert.NoError(t, err)
@@ -745,3 +780,154 @@ func TestReadEntriesOfTransaction(t *testing.T) {
 	assert.Equal(t, entr.EntrySide, resultEntr[0].EntrySide)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
+
+func TestWriteTransaction_WithTx(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	firstAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Debit))
+	secondAccount := pelucio.NewAccount(xtime.DefaultClock, pelucio.WithNormalSide(pelucio.Credit))
+	transaction := pelucio.Deposit("external", firstAccount.ID, secondAccount.ID, big.NewInt(100), "USD")
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO orders").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO entries").WillReturnResult(sqlmock.NewResult(1, 2))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO account_balances").WillReturnResult(sqlmock.NewResult(1, 2))
+	mock.ExpectCommit()
+
+	tx, err := db.DB.Beginx()
+	assert.NoError(t, err)
+
+	_, err = tx.Exec("INSERT INTO orders (id) VALUES (1)")
+	assert.NoError(t, err)
+
+	err = db.WithTx(tx).WriteTransaction(context.Background(), transaction, firstAccount, secondAccount)
+	assert.NoError(t, err)
+
+	assert.NoError(t, tx.Commit())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRunInTx_RollbackOnError(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	acc := pelucio.NewAccount(xtime.DefaultClock,
+		pelucio.WithExternalID("extid"),
+		pelucio.WithNormalSide(pelucio.Debit))
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO accounts").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectRollback()
+
+	expectedErr := errors.New("outbox write failed")
+	err := db.RunInTx(context.Background(), func(rw *ReadWriterPG) error {
+		if err := rw.WriteAccount(context.Background(), acc, false); err != nil {
+			return err
+		}
+		return expectedErr
+	})
+	assert.ErrorIs(t, err, expectedErr)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestNullBigInt_Scan(t *testing.T) {
+	cases := []struct {
+		name     string
+		value    interface{}
+		expected string
+	}{
+		{"numeric", []byte("123456789012345678901234567890"), "123456789012345678901234567890"},
+		{"text", "100", "100"},
+		{"integer", int64(42), "42"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var n NullBigInt
+			assert.NoError(t, n.Scan(c.value))
+			assert.True(t, n.Valid)
+			assert.Equal(t, c.expected, n.Amount.String())
+		})
+	}
+
+	var n NullBigInt
+	assert.NoError(t, n.Scan(nil))
+	assert.False(t, n.Valid)
+	assert.Error(t, n.Scan([]byte("10.5")))
+	assert.Error(t, n.Scan(1.5))
+}
+
+type timeArg time.Time
+
+func (p timeArg) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	return ok && t.Equal(time.Time(p))
+}
+
+// nextPageToken is the token the list methods return after a row.
+func nextPageToken(rw *ReadWriterPG, fingerprint []byte, createdAt time.Time, id uuid.UUID) string {
+	return rw.generatePaginationToken(pageCursor{
+		Direction: pageNext,
+		SortKey:   SortByCreatedAt,
+		Order:     Descending,
+		Key:       createdAt,
+		ID:        id,
+	}, fingerprint)
+}
+
+func TestReadEntriesPage_KeysetFromToken(t *testing.T) {
+	columns := []string{"id", "transaction_id", "account_id", "entry_side", "account_side", "amount", "currency", "created_at"}
+	cases := []struct {
+		name      string
+		order     SortOrder
+		direction pageDirection
+		operator  string
+		scan      string
+	}{
+		{"descending next", Descending, pageNext, "<", "DESC"},
+		{"descending prev", Descending, pagePrev, ">", "ASC"},
+		{"ascending next", Ascending, pageNext, ">", "ASC"},
+		{"ascending prev", Ascending, pagePrev, "<", "DESC"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db, mock, cleanup := setupMockDB(t)
+			defer cleanup()
+
+			limit := uint(2)
+			key := time.Now().UTC().Truncate(time.Microsecond)
+			cursorID, first, second := xuuid.New(), xuuid.New(), xuuid.New()
+			token := db.generatePaginationToken(pageCursor{
+				Direction: c.direction,
+				SortKey:   SortByCreatedAt,
+				Order:     c.order,
+				Key:       key,
+				ID:        cursorID,
+			}, entriesFingerprint(pelucio.ReadEntryFilter{}))
+
+			// a previous page is scanned backwards from the cursor
+			mock.ExpectQuery("^"+regexp.QuoteMeta("SELECT * FROM entries AS entries "+
+				"WHERE (entries.created_at, entries.id) "+c.operator+" ($1, $2) "+
+				"ORDER BY entries.created_at "+c.scan+", entries.id "+c.scan+" LIMIT $3")+"$").
+				WithArgs(timeArg(key), cursorID, limit+1).
+				WillReturnRows(sqlmock.NewRows(columns).
+					AddRow(first, xuuid.New(), xuuid.New(), pelucio.Debit, pelucio.Debit, "1", "BRL", key).
+					AddRow(second, xuuid.New(), xuuid.New(), pelucio.Debit, pelucio.Debit, "1", "BRL", key))
+
+			page, err := db.ReadEntriesPage(context.Background(), pelucio.ReadEntryFilter{Limit: &limit, PaginationToken: &token}, PageOptions{})
+			assert.NoError(t, err)
+			ids := []uuid.UUID{page.Items[0].ID, page.Items[1].ID}
+			if c.direction == pagePrev {
+				assert.Equal(t, []uuid.UUID{second, first}, ids)
+			} else {
+				assert.Equal(t, []uuid.UUID{first, second}, ids)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
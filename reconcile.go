@@ -0,0 +1,188 @@
+package peluciopg
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/devmalloni/pelucio"
+	"github.com/devmalloni/pelucio/x/xuuid"
+	"github.com/gofrs/uuid/v5"
+	"github.com/lib/pq"
+)
+
+var ErrInvalidRepair = errors.New("balance repair requires who performs it and why")
+
+// ReconcileOptions restricts reconciliation to AccountIDs, or every account
+// when empty. With Repair set, discrepancies are fixed by rewriting the stored
+// balance from the entries, audited in balance_repairs under PerformedBy and
+// Reason.
+type ReconcileOptions struct {
+	AccountIDs  []uuid.UUID
+	Repair      bool
+	PerformedBy string
+	Reason      string
+}
+
+// BalanceDiscrepancy is a stored balance that differs from the sum of the
+// entries of the account in a currency.
+type BalanceDiscrepancy struct {
+	AccountID uuid.UUID        `json:"account_id"`
+	Currency  pelucio.Currency `json:"currency"`
+	Stored    *big.Int         `json:"stored"`
+	Computed  *big.Int         `json:"computed"`
+	Repaired  bool             `json:"repaired"`
+}
+
+// Difference is how much the stored balance is over the computed one.
+func (p *BalanceDiscrepancy) Difference() *big.Int {
+	return new(big.Int).Sub(p.Stored, p.Computed)
+}
+
+type balanceRepair struct {
+	ID             uuid.UUID        `db:"id"`
+	AccountID      uuid.UUID        `db:"account_id"`
+	Currency       pelucio.Currency `db:"currency"`
+	StoredAmount   NullBigInt       `db:"stored_amount"`
+	ComputedAmount NullBigInt       `db:"computed_amount"`
+	PerformedBy    string           `db:"performed_by"`
+	Reason         string           `db:"reason"`
+	CreatedAt      time.Time        `db:"created_at"`
+}
+
+// Reconcile recomputes the balances of accounts from their entries and
+// returns where they differ from the stored ones.
+func (rw *ReadWriterPG) Reconcile(ctx context.Context, opts ReconcileOptions) ([]*BalanceDiscrepancy, error) {
+	if !opts.Repair {
+		rows, err := rw.balanceDiscrepancies(ctx, opts.AccountIDs)
+		if err != nil {
+			return nil, err
+		}
+		return toDiscrepancies(rows, false), nil
+	}
+
+	if opts.PerformedBy == "" || opts.Reason == "" {
+		return nil, ErrInvalidRepair
+	}
+
+	var discrepancies []*BalanceDiscrepancy
+	err := rw.RunInTx(ctx, func(rw *ReadWriterPG) error {
+		rows, err := rw.balanceDiscrepancies(ctx, opts.AccountIDs)
+		if err != nil || len(rows) == 0 {
+			discrepancies = toDiscrepancies(rows, false)
+			return err
+		}
+
+		// writers hold the account row until they commit, so once locked a
+		// second look only sees balances no write is still moving. Writers
+		// given no accounts only hold the balance rows they increment, which
+		// are locked too, so increments committed meanwhile are seen and later
+		// ones wait for the repair.
+		accountIDs := make([]uuid.UUID, 0, len(rows))
+		for _, row := range rows {
+			accountIDs = append(accountIDs, row.AccountID)
+		}
+		if err := rw.lockAccounts(ctx, accountIDs); err != nil {
+			return err
+		}
+		if err := rw.lockBalances(ctx, accountIDs); err != nil {
+			return err
+		}
+		rows, err = rw.balanceDiscrepancies(ctx, accountIDs)
+		if err != nil || len(rows) == 0 {
+			discrepancies = toDiscrepancies(rows, false)
+			return err
+		}
+
+		now := rw.now()
+		for _, row := range rows {
+			row.ID = xuuid.New()
+			row.PerformedBy = opts.PerformedBy
+			row.Reason = opts.Reason
+			row.CreatedAt = now
+		}
+
+		_, err = rw.namedExecContext(ctx, rw.ext(), `
+			INSERT INTO `+rw.table("account_balances")+` AS account_balances (account_id, currency, amount)
+			VALUES (:account_id, :currency, :computed_amount)
+			ON CONFLICT (account_id, currency) DO UPDATE SET
+				amount = EXCLUDED.amount
+		`, rows)
+		if err != nil {
+			return err
+		}
+
+		_, err = rw.namedExecContext(ctx, rw.ext(), `
+			INSERT INTO `+rw.table("balance_repairs")+` (id, account_id, currency, stored_amount, computed_amount, performed_by, reason, created_at)
+			VALUES (:id, :account_id, :currency, :stored_amount, :computed_amount, :performed_by, :reason, :created_at)
+		`, rows)
+		if err != nil {
+			return err
+		}
+
+		discrepancies = toDiscrepancies(rows, true)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return discrepancies, nil
+}
+
+// balanceDiscrepancies compares the stored balances with the entries of the
+// given accounts, or of every account when accountIDs is empty.
+func (rw *ReadWriterPG) balanceDiscrepancies(ctx context.Context, accountIDs []uuid.UUID) ([]*balanceRepair, error) {
+	var args []interface{}
+	computedFilter, storedFilter := "", ""
+	if len(accountIDs) > 0 {
+		computedFilter = "WHERE entries.account_id = ANY($1::uuid[])"
+		storedFilter = "WHERE account_balances.account_id = ANY($1::uuid[])"
+		args = append(args, pq.Array(xuuid.ToStrings(accountIDs...)))
+	}
+
+	rows := []*balanceRepair{}
+	err := rw.selectContext(ctx, rw.ext(), &rows, `
+		SELECT COALESCE(computed.account_id, stored.account_id) AS account_id,
+			COALESCE(computed.currency, stored.currency) AS currency,
+			COALESCE(stored.amount, 0) AS stored_amount,
+			COALESCE(computed.amount, 0) AS computed_amount
+		FROM (
+			SELECT entries.account_id,
+				entries.currency,
+				SUM(CASE WHEN entries.entry_side = accounts.normal_side THEN entries.amount ELSE -entries.amount END) AS amount
+			FROM `+rw.table("entries")+` AS entries
+			JOIN `+rw.table("accounts")+` AS accounts ON accounts.id = entries.account_id
+			`+computedFilter+`
+			GROUP BY entries.account_id, entries.currency
+		) AS computed
+		FULL OUTER JOIN (
+			SELECT account_balances.account_id, account_balances.currency, account_balances.amount
+			FROM `+rw.table("account_balances")+` AS account_balances
+			`+storedFilter+`
+		) AS stored ON stored.account_id = computed.account_id AND stored.currency = computed.currency
+		WHERE COALESCE(stored.amount, 0) <> COALESCE(computed.amount, 0)
+		ORDER BY account_id, currency
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+func toDiscrepancies(rows []*balanceRepair, repaired bool) []*BalanceDiscrepancy {
+	discrepancies := make([]*BalanceDiscrepancy, len(rows))
+	for i, row := range rows {
+		discrepancies[i] = &BalanceDiscrepancy{
+			AccountID: row.AccountID,
+			Currency:  row.Currency,
+			Stored:    amountOrZero(row.StoredAmount),
+			Computed:  amountOrZero(row.ComputedAmount),
+			Repaired:  repaired,
+		}
+	}
+
+	return discrepancies
+}
@@ -0,0 +1,72 @@
+package peluciopg
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/devmalloni/pelucio/x/xuuid"
+	"github.com/gofrs/uuid/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconcile(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	accID := xuuid.New()
+	mock.ExpectQuery("SELECT COALESCE\\(computed.account_id, stored.account_id\\) AS account_id, (.+) FULL OUTER JOIN").
+		WillReturnRows(sqlmock.NewRows([]string{"account_id", "currency", "stored_amount", "computed_amount"}).
+			AddRow(accID, "BRL", []byte("120"), []byte("100")))
+
+	discrepancies, err := db.Reconcile(context.Background(), ReconcileOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, discrepancies, 1)
+	assert.Equal(t, accID, discrepancies[0].AccountID)
+	assert.Equal(t, int64(20), discrepancies[0].Difference().Int64())
+	assert.False(t, discrepancies[0].Repaired)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReconcile_Repair(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	accID := xuuid.New()
+	columns := []string{"account_id", "currency", "stored_amount", "computed_amount"}
+	mock.ExpectBegin()
+	mock.ExpectQuery("FULL OUTER JOIN").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(accID, "BRL", []byte("120"), []byte("100")))
+	mock.ExpectQuery("SELECT id FROM accounts WHERE id = ANY\\(\\$1::uuid\\[\\]\\) ORDER BY id FOR UPDATE").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(accID))
+	// writers given no accounts are held back by the balance rows
+	mock.ExpectQuery("SELECT account_id FROM account_balances WHERE account_id = ANY\\(\\$1::uuid\\[\\]\\) ORDER BY account_id, currency FOR UPDATE").
+		WillReturnRows(sqlmock.NewRows([]string{"account_id"}).AddRow(accID))
+	mock.ExpectQuery("WHERE entries.account_id = ANY\\(\\$1::uuid\\[\\]\\)").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(accID, "BRL", []byte("120"), []byte("100")))
+	mock.ExpectExec("INSERT INTO account_balances AS account_balances (.+) DO UPDATE SET amount = EXCLUDED.amount").
+		WithArgs(accID, "BRL", "100").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO balance_repairs").
+		WithArgs(sqlmock.AnyArg(), accID, "BRL", "120", "100", "ops", "drift", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	discrepancies, err := db.Reconcile(context.Background(), ReconcileOptions{
+		Repair:      true,
+		PerformedBy: "ops",
+		Reason:      "drift",
+	})
+	assert.NoError(t, err)
+	assert.Len(t, discrepancies, 1)
+	assert.True(t, discrepancies[0].Repaired)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReconcile_RepairRequiresAudit(t *testing.T) {
+	db, _, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	_, err := db.Reconcile(context.Background(), ReconcileOptions{AccountIDs: []uuid.UUID{xuuid.New()}, Repair: true})
+	assert.ErrorIs(t, err, ErrInvalidRepair)
+}
@@ -0,0 +1,175 @@
+package peluciopg
+
+import (
+	"context"
+	"time"
+)
+
+// Publisher delivers ledger events downstream. An event may be published
+// more than once, when marking it delivered fails, so Publish should be
+// idempotent on the event ID.
+type Publisher interface {
+	Publish(ctx context.Context, event *LedgerEvent) error
+}
+
+const (
+	DefaultRelayBatchSize    = 100
+	DefaultRelayPollInterval = time.Second
+)
+
+// DefaultRelayRetryPolicy gives up on an event after ten failed attempts,
+// backing off from one second up to five minutes between them.
+func DefaultRelayRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 10,
+		BaseDelay:   time.Second,
+		MaxDelay:    5 * time.Minute,
+	}
+}
+
+// EventRelay moves the events of the ledger_events outbox to a Publisher.
+// Several relays can run against the same database: each claims its own
+// batch of events. Events are published in id order, except for the ones
+// waiting to be retried, which later events overtake.
+type EventRelay struct {
+	rw           *ReadWriterPG
+	publisher    Publisher
+	batchSize    int
+	pollInterval time.Duration
+	retryPolicy  RetryPolicy
+}
+
+type EventRelayOpt func(r *EventRelay)
+
+func NewEventRelay(rw *ReadWriterPG, publisher Publisher, opts ...EventRelayOpt) *EventRelay {
+	r := &EventRelay{
+		rw:           rw,
+		publisher:    publisher,
+		batchSize:    DefaultRelayBatchSize,
+		pollInterval: DefaultRelayPollInterval,
+		retryPolicy:  DefaultRelayRetryPolicy(),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// WithRelayBatchSize sets how many events are claimed at a time.
+func WithRelayBatchSize(n int) EventRelayOpt {
+	return func(r *EventRelay) {
+		r.batchSize = n
+	}
+}
+
+// WithRelayPollInterval sets how long the relay waits for new events once
+// the outbox is drained.
+func WithRelayPollInterval(d time.Duration) EventRelayOpt {
+	return func(r *EventRelay) {
+		r.pollInterval = d
+	}
+}
+
+// WithRelayRetryPolicy sets how failed publications are retried. Once
+// MaxAttempts publications of an event failed, it is dead-lettered: marked
+// dead and left in the outbox for inspection.
+func WithRelayRetryPolicy(policy RetryPolicy) EventRelayOpt {
+	return func(r *EventRelay) {
+		r.retryPolicy = policy
+	}
+}
+
+// Run relays events until ctx is done, returning its error.
+func (r *EventRelay) Run(ctx context.Context) error {
+	for {
+		n, err := r.RelayOnce(ctx)
+		if err != nil && r.rw.logger != nil {
+			r.rw.logger.ErrorContext(ctx, "peluciopg: relaying ledger events", "error", err.Error())
+		}
+		if err == nil && n == r.batchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.pollInterval):
+		}
+	}
+}
+
+// RelayOnce claims a batch of the events due for publication and publishes
+// them, returning how many it claimed. Claimed events stay locked until
+// their outcome is recorded, so other relays skip them.
+func (r *EventRelay) RelayOnce(ctx context.Context) (int, error) {
+	claimed := 0
+	err := r.rw.RunInTx(ctx, func(rw *ReadWriterPG) error {
+		events := []*LedgerEvent{}
+		err := rw.selectContext(ctx, rw.ext(), &events, `
+			SELECT id, event_type, transaction_id, account_id, payload, attempts, created_at
+			FROM `+rw.table("ledger_events")+`
+			WHERE status = 'pending' AND available_at <= $1
+			ORDER BY id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		`, rw.now(), r.batchSize)
+		if err != nil {
+			return err
+		}
+		claimed = len(events)
+
+		for _, event := range events {
+			if err := r.publish(ctx, rw, event); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return claimed, err
+}
+
+// publish hands event to the publisher and records the outcome.
+func (r *EventRelay) publish(ctx context.Context, rw *ReadWriterPG, event *LedgerEvent) error {
+	outcome := map[string]interface{}{
+		"id":           event.ID,
+		"status":       "delivered",
+		"attempts":     event.Attempts + 1,
+		"last_error":   nil,
+		"available_at": rw.now(),
+		"delivered_at": rw.now(),
+	}
+
+	if err := r.publisher.Publish(ctx, event); err != nil {
+		outcome["last_error"] = err.Error()
+		outcome["delivered_at"] = nil
+		if event.Attempts+1 >= r.retryPolicy.MaxAttempts {
+			outcome["status"] = "dead"
+		} else {
+			outcome["status"] = "pending"
+			outcome["available_at"] = rw.now().Add(r.retryPolicy.backoff(event.Attempts + 1))
+		}
+
+		if rw.logger != nil {
+			rw.logger.WarnContext(ctx, "peluciopg: publishing ledger event",
+				"event_id", event.ID,
+				"attempt", event.Attempts+1,
+				"status", outcome["status"],
+				"error", err.Error())
+		}
+	}
+
+	_, err := rw.namedExecContext(ctx, rw.ext(), `
+		UPDATE `+rw.table("ledger_events")+` SET status = :status,
+			attempts = :attempts,
+			last_error = :last_error,
+			available_at = :available_at,
+			delivered_at = :delivered_at
+		WHERE id = :id
+	`, outcome)
+
+	return err
+}
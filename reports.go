@@ -0,0 +1,160 @@
+package peluciopg
+
+import (
+	"context"
+	"database/sql"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/devmalloni/pelucio"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	TrialBalanceFilter struct {
+		AccountIDs []string           `json:"account_ids,omitempty"`
+		Currencies []pelucio.Currency `json:"currencies,omitempty"`
+	}
+
+	// TrialBalanceLine holds the debit and credit totals of an account in a
+	// currency. Balance is their difference on the account normal side.
+	TrialBalanceLine struct {
+		AccountID  uuid.UUID         `json:"account_id"`
+		ExternalID string            `json:"external_id"`
+		Name       string            `json:"name"`
+		NormalSide pelucio.EntrySide `json:"normal_side"`
+		Currency   pelucio.Currency  `json:"currency"`
+		Debits     *big.Int          `json:"debits"`
+		Credits    *big.Int          `json:"credits"`
+		Balance    *big.Int          `json:"balance"`
+	}
+
+	TrialBalanceTotal struct {
+		Currency pelucio.Currency `json:"currency"`
+		Debits   *big.Int         `json:"debits"`
+		Credits  *big.Int         `json:"credits"`
+		Balanced bool             `json:"balanced"`
+	}
+
+	// TrialBalance proves the books balance as of a date: for every currency,
+	// total debits must equal total credits. Currencies where they differ are
+	// listed in UnbalancedCurrencies.
+	TrialBalance struct {
+		AsOf                 time.Time            `json:"as_of"`
+		Lines                []*TrialBalanceLine  `json:"lines"`
+		Totals               []*TrialBalanceTotal `json:"totals"`
+		UnbalancedCurrencies []pelucio.Currency   `json:"unbalanced_currencies"`
+	}
+)
+
+func (p *TrialBalance) IsBalanced() bool {
+	return len(p.UnbalancedCurrencies) == 0
+}
+
+type trialBalanceRow struct {
+	IsTotal    bool           `db:"is_total"`
+	AccountID  uuid.NullUUID  `db:"account_id"`
+	ExternalID sql.NullString `db:"external_id"`
+	Name       sql.NullString `db:"name"`
+	NormalSide sql.NullString `db:"normal_side"`
+	Currency   string         `db:"currency"`
+	Debits     NullBigInt     `db:"debits"`
+	Credits    NullBigInt     `db:"credits"`
+}
+
+// TrialBalance totals debits and credits per account and currency over the
+// transactions executed up to asOf, along with the overall totals per currency.
+func (rw *ReadWriterPG) TrialBalance(ctx context.Context, asOf time.Time, filter TrialBalanceFilter) (*TrialBalance, error) {
+	conditions := []string{"transactions.executed_at <= ?"}
+	args := []interface{}{asOf}
+
+	if len(filter.AccountIDs) > 0 {
+		q, argss, _ := sqlx.In("entries.account_id IN (?)", filter.AccountIDs)
+		conditions = append(conditions, q)
+		args = append(args, argss...)
+	}
+	if len(filter.Currencies) > 0 {
+		q, argss, _ := sqlx.In("entries.currency IN (?)", filter.Currencies)
+		conditions = append(conditions, q)
+		args = append(args, argss...)
+	}
+
+	query := `
+		SELECT GROUPING(accounts.id) = 1 AS is_total,
+			accounts.id AS account_id,
+			accounts.external_id,
+			accounts.name,
+			accounts.normal_side,
+			entries.currency,
+			SUM(CASE WHEN entries.entry_side = 'debit' THEN entries.amount ELSE 0 END) AS debits,
+			SUM(CASE WHEN entries.entry_side = 'credit' THEN entries.amount ELSE 0 END) AS credits
+		FROM ` + rw.table("entries") + ` AS entries
+		JOIN ` + rw.table("transactions") + ` AS transactions ON transactions.id = entries.transaction_id
+		JOIN ` + rw.table("accounts") + ` AS accounts ON accounts.id = entries.account_id
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		GROUP BY GROUPING SETS (
+			(entries.currency, accounts.id, accounts.external_id, accounts.name, accounts.normal_side),
+			(entries.currency)
+		)
+		ORDER BY entries.currency, is_total, accounts.external_id`
+	query = rw.DB.Rebind(query)
+
+	rows := []*trialBalanceRow{}
+	err := rw.selectContext(ctx, rw.ext(), &rows, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &TrialBalance{
+		AsOf:                 asOf,
+		Lines:                []*TrialBalanceLine{},
+		Totals:               []*TrialBalanceTotal{},
+		UnbalancedCurrencies: []pelucio.Currency{},
+	}
+	for _, row := range rows {
+		debits, credits := amountOrZero(row.Debits), amountOrZero(row.Credits)
+		currency := pelucio.Currency(row.Currency)
+
+		if row.IsTotal {
+			total := &TrialBalanceTotal{
+				Currency: currency,
+				Debits:   debits,
+				Credits:  credits,
+				Balanced: debits.Cmp(credits) == 0,
+			}
+			report.Totals = append(report.Totals, total)
+			if !total.Balanced {
+				report.UnbalancedCurrencies = append(report.UnbalancedCurrencies, currency)
+			}
+			continue
+		}
+
+		line := &TrialBalanceLine{
+			AccountID:  row.AccountID.UUID,
+			ExternalID: row.ExternalID.String,
+			Name:       row.Name.String,
+			NormalSide: pelucio.EntrySide(row.NormalSide.String),
+			Currency:   currency,
+			Debits:     debits,
+			Credits:    credits,
+		}
+		if line.NormalSide == pelucio.Debit {
+			line.Balance = new(big.Int).Sub(debits, credits)
+		} else {
+			line.Balance = new(big.Int).Sub(credits, debits)
+		}
+		report.Lines = append(report.Lines, line)
+	}
+
+	return report, nil
+}
+
+func amountOrZero(n NullBigInt) *big.Int {
+	if !n.Valid {
+		return new(big.Int)
+	}
+
+	return n.Amount
+}
@@ -0,0 +1,57 @@
+package peluciopg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/devmalloni/pelucio"
+	"github.com/devmalloni/pelucio/x/xuuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrialBalance(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	asOf := time.Now()
+	cashID := xuuid.New()
+	revenueID := xuuid.New()
+
+	columns := []string{"is_total", "account_id", "external_id", "name", "normal_side", "currency", "debits", "credits"}
+	mock.ExpectQuery("SELECT GROUPING\\(accounts.id\\) = 1 AS is_total(.+) WHERE transactions.executed_at <= \\$1 AND entries.currency IN \\(\\$2\\) GROUP BY GROUPING SETS").
+		WithArgs(asOf, pelucio.Currency("BRL")).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(false, cashID, "cash", "Cash", "debit", "BRL", []byte("150"), []byte("50")).
+			AddRow(false, revenueID, "revenue", "Revenue", "credit", "BRL", []byte("0"), []byte("100")).
+			AddRow(true, nil, nil, nil, nil, "BRL", []byte("150"), []byte("150")))
+
+	report, err := db.TrialBalance(context.Background(), asOf, TrialBalanceFilter{Currencies: []pelucio.Currency{"BRL"}})
+	assert.NoError(t, err)
+	assert.True(t, report.IsBalanced())
+	assert.Len(t, report.Lines, 2)
+	assert.Equal(t, cashID, report.Lines[0].AccountID)
+	assert.Equal(t, int64(100), report.Lines[0].Balance.Int64())
+	assert.Equal(t, int64(100), report.Lines[1].Balance.Int64())
+	assert.Len(t, report.Totals, 1)
+	assert.True(t, report.Totals[0].Balanced)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrialBalance_Unbalanced(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	columns := []string{"is_total", "account_id", "external_id", "name", "normal_side", "currency", "debits", "credits"}
+	mock.ExpectQuery("SELECT GROUPING").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(true, nil, nil, nil, nil, "BRL", []byte("150"), []byte("150")).
+			AddRow(true, nil, nil, nil, nil, "USD", []byte("10"), []byte("7")))
+
+	report, err := db.TrialBalance(context.Background(), time.Now(), TrialBalanceFilter{})
+	assert.NoError(t, err)
+	assert.False(t, report.IsBalanced())
+	assert.Equal(t, []pelucio.Currency{"USD"}, report.UnbalancedCurrencies)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,159 @@
+package peluciopg
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/devmalloni/pelucio"
+	"github.com/devmalloni/pelucio/x/xuuid"
+	"github.com/gofrs/uuid/v5"
+	"github.com/lib/pq"
+)
+
+const (
+	SQLStateSerializationFailure = "40001"
+	SQLStateDeadlockDetected     = "40P01"
+)
+
+// RetryHook is called before a failed WriteTransaction is attempted again.
+// It returns the accounts the next attempt must write, usually reloaded from
+// the database so their versions and balances are current.
+type RetryHook func(ctx context.Context,
+	rw *ReadWriterPG,
+	attempt int,
+	err error,
+	transaction *pelucio.Transaction,
+	accounts []*pelucio.Account) ([]*pelucio.Account, error)
+
+// RetryPolicy controls how WriteTransaction retries on contention.
+// Serialization failures and deadlocks are retried as configured by
+// RetryableSQLStates. Version conflicts are retried only when OnRetry is set,
+// since writing the same stale accounts again would fail the same way.
+type RetryPolicy struct {
+	MaxAttempts        int
+	BaseDelay          time.Duration
+	MaxDelay           time.Duration
+	RetryableSQLStates []string
+	OnRetry            RetryHook
+}
+
+// DefaultRetryPolicy retries serialization failures and deadlocks, and
+// reloads accounts on version conflicts.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:        5,
+		BaseDelay:          10 * time.Millisecond,
+		MaxDelay:           time.Second,
+		RetryableSQLStates: []string{SQLStateSerializationFailure, SQLStateDeadlockDetected},
+		OnRetry:            ReloadAccounts,
+	}
+}
+
+// ReloadAccounts is a RetryHook that reads the accounts again and applies the
+// transaction entries to their current balances.
+func ReloadAccounts(ctx context.Context,
+	rw *ReadWriterPG,
+	attempt int,
+	err error,
+	transaction *pelucio.Transaction,
+	accounts []*pelucio.Account) ([]*pelucio.Account, error) {
+	reloaded := make([]*pelucio.Account, 0, len(accounts))
+	for _, acc := range accounts {
+		fresh, err := rw.ReadAccount(ctx, acc.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range transaction.Entries {
+			if !xuuid.Equal(e.AccountID, fresh.ID) {
+				continue
+			}
+
+			if err := fresh.Apply(*e, rw.clockOrDefault()); err != nil {
+				return nil, err
+			}
+		}
+
+		reloaded = append(reloaded, fresh)
+	}
+
+	return reloaded, nil
+}
+
+func (p RetryPolicy) isRetryable(err error) bool {
+	if errors.Is(err, ErrVersionConflict) {
+		return p.OnRetry != nil
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return slices.Contains(p.RetryableSQLStates, string(pqErr.Code))
+	}
+
+	return false
+}
+
+// backoff returns the delay before the given attempt, doubling from BaseDelay
+// up to MaxDelay, with half of it randomized to spread competing writers.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << (attempt - 1)
+	if delay <= 0 || (p.MaxDelay > 0 && delay > p.MaxDelay) {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
+
+// writeWithRetry runs write with the configured retry policy. Writes bound to a
+// caller-supplied transaction are never retried, because the failed
+// transaction belongs to the caller.
+func (rw *ReadWriterPG) writeWithRetry(ctx context.Context,
+	transaction *pelucio.Transaction,
+	accounts []*pelucio.Account,
+	write func(accounts []*pelucio.Account) error) error {
+	policy := rw.retryPolicy
+	if rw.tx != nil || policy.MaxAttempts <= 1 {
+		return write(accounts)
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = write(accounts)
+		if err == nil || attempt >= policy.MaxAttempts || !policy.isRetryable(err) {
+			return err
+		}
+
+		rw.logRetry(ctx, transaction.ID, attempt, err)
+
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(policy.backoff(attempt)):
+		}
+
+		if policy.OnRetry != nil {
+			accounts, err = policy.OnRetry(ctx, rw, attempt, err, transaction, accounts)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (rw *ReadWriterPG) logRetry(ctx context.Context, transactionID uuid.UUID, attempt int, err error) {
+	if rw.logger == nil {
+		return
+	}
+
+	rw.logger.DebugContext(ctx, "peluciopg: retrying transaction write",
+		"transaction_id", transactionID.String(),
+		"attempt", attempt,
+		"error", err.Error())
+}